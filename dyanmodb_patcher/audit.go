@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/audit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+const (
+	auditSinkFile  = "file"
+	auditSinkTable = "table"
+	auditSinkOff   = "off"
+)
+
+func validateAuditFlag() error {
+	switch auditFlag {
+	case auditSinkFile, auditSinkTable, auditSinkOff:
+		return nil
+	}
+	return fmt.Errorf("audit flag should be %s, %s or %s", auditSinkFile, auditSinkTable, auditSinkOff)
+}
+
+// operator returns the email of who runs the patcher, it's recorded in the audit records.
+func operator() string {
+	return os.Getenv("EMAIL")
+}
+
+func auditFilePath() string {
+	if auditFileFlag != "" {
+		return auditFileFlag
+	}
+	return filepath.Join(outputDirFlag, audit.FileName)
+}
+
+// openAudit opens the audit file when audit flag is file, it's nil otherwise. Writes can't be audited without the
+// operator, so it fails when EMAIL is not set unless audit is off.
+func openAudit() (*audit.File, error) {
+	if auditFlag == auditSinkOff {
+		return nil, nil
+	}
+	if operator() == "" {
+		return nil, fmt.Errorf("EMAIL env variable is required to audit the writes, please edit .env file or set audit flag to %s", auditSinkOff)
+	}
+	if auditFlag != auditSinkFile {
+		return nil, nil
+	}
+	return audit.Open(auditFilePath())
+}
+
+// withAudit appends the option auditing the writes of a repository on ddbClient to opts, the records go to
+// auditFile or to tableName depending on audit flag. opts are returned as is when audit is off.
+func withAudit(opts []tovendor.Option, auditFile *audit.File, ddbClient *dynamodb.Client, tableName string, writeLimiter *ratelimit.Limiter, meta tovendor.AuditMetadata) []tovendor.Option {
+	switch {
+	case auditFlag == auditSinkTable:
+		return append(opts, tovendor.WithAudit(tovendor.NewAuditTable(ddbClient, tableName, writeLimiter), meta))
+	case auditFlag == auditSinkFile && auditFile != nil:
+		return append(opts, tovendor.WithAudit(auditFile, meta))
+	}
+	return opts
+}
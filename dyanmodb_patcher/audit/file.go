@@ -0,0 +1,99 @@
+// Package audit keeps the audit records of the writes of every run in a JSONL file, it's the alternative to the
+// audit items of tovendor.AuditTable.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// FileName is the default file of audit records under the output directory, it's shared by the runs.
+const FileName = "audit.jsonl"
+
+// File appends audit records to a JSONL file, it's safe for concurrent use.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the file at path to append records to it, the file is created if it doesn't exist.
+func Open(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	return &File{file: file}, nil
+}
+
+func (f *File) Write(ctx context.Context, record tovendor.AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return f.file.Sync()
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// History returns the records of vendorCode at path in the order they were written, only of geid when it's set.
+// A cut off last line is skipped with a warning.
+func History(path, geid, vendorCode string, logger *slog.Logger) ([]tovendor.AuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer file.Close()
+
+	var records []tovendor.AuditRecord
+	var malformed error
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if malformed != nil {
+			return nil, malformed
+		}
+
+		var record tovendor.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			malformed = fmt.Errorf("malformed audit record at line %d: %w", lineNo, err)
+			continue
+		}
+		if record.VendorCode == vendorCode && (geid == "" || record.GEID == geid) {
+			records = append(records, record)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+
+	if malformed != nil {
+		logger.Warn("Skip the last audit record, it was cut off", "error", malformed)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs", FileName)
+	file, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := "Legal One"
+	records := []tovendor.AuditRecord{
+		{GEID: "FP_SG", VendorCode: "v001", Attribute: "legal_name", NewValue: &name},
+		{GEID: "FP_SG", VendorCode: "v002", Attribute: "legal_name", NewValue: &name},
+		{GEID: "FP_TW", VendorCode: "v001", Attribute: "legal_name", OldValue: &name},
+	}
+	for i, record := range records {
+		record.Time = time.Date(2026, 10, 18, 0, 0, i, 0, time.UTC)
+		if err := file.Write(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := History(path, "", "v001", slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].GEID != "FP_SG" || all[1].GEID != "FP_TW" || all[1].NewValue != nil || *all[1].OldValue != name {
+		t.Errorf("history of v001 is %+v", all)
+	}
+
+	sg, err := History(path, "FP_SG", "v001", slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sg) != 1 || sg[0].GEID != "FP_SG" {
+		t.Errorf("history of v001 in FP_SG is %+v", sg)
+	}
+}
+
+func TestHistorySkipsCutOffLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte("{\"vendor_code\":\"v001\"}\n{\"vendor_co"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	records, err := History(path, "", "v001", slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].VendorCode != "v001" {
+		t.Errorf("records = %+v, want the one of v001", records)
+	}
+	if !strings.Contains(logs.String(), "line 2") {
+		t.Errorf("cut off record is not warned: %s", logs.String())
+	}
+}
+
+func TestHistoryMalformedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte("{\"vendor_code\":\"v001\"}\nnot json\n{\"vendor_code\":\"v001\"}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := History(path, "", "v001", slog.Default()); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected the malformed line 2 to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb/memory"
+)
+
+const (
+	backendAWS    = "aws"
+	backendMemory = "memory"
+)
+
+// The tables of memory backend are saved under the run directory, pass them to fixture flag to verify or undo the run.
+const (
+	memoryTablesFile       = "memory-tables.json"
+	memoryTablesUndoneFile = "memory-tables-undone.json"
+)
+
+// memoryDB is the DynamoDB of the memory backend, it's shared by all GEIDs of the run.
+var memoryDB *memory.DB
+
+// openBackend prepares the DynamoDB backend selected by backend flag.
+func openBackend() error {
+	switch backendFlag {
+	case backendAWS:
+		return nil
+	case backendMemory:
+		if fixtureFlag == "" {
+			return fmt.Errorf("fixture flag is required with %s backend", backendMemory)
+		}
+
+		db, err := memory.Load(fixtureFlag)
+		if err != nil {
+			return err
+		}
+		memoryDB = db
+		slog.Info("Use the in-memory DynamoDB", "fixture", fixtureFlag)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported backend %s, it should be %s or %s", backendFlag, backendAWS, backendMemory)
+}
+
+func newDDBClient(awsCfg config.AWS) (*dynamodb.Client, error) {
+	if memoryDB != nil {
+		return dynamodb.NewClientFrom(memoryDB), nil
+	}
+	return dynamodb.NewClient(awsCfg)
+}
+
+// saveBackend saves the tables of memory backend to fileName under the run directory, it's a no-op for AWS.
+func saveBackend(runID, fileName string) {
+	if memoryDB == nil {
+		return
+	}
+
+	path := filepath.Join(runDir(runID), fileName)
+	if err := memoryDB.WriteFixture(path); err != nil {
+		slog.Error("Failed to save the in-memory tables", "run_id", runID, "error", err)
+		return
+	}
+	slog.Info("In-memory tables are saved", "run_id", runID, "path", path)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/report"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// verifyCanary re-reads the vendors updated in the canary and checks the written values match the proposed ones.
+// A failed patch or a mismatch is counted as an error of the canary.
+func verifyCanary(ctx context.Context, logger *slog.Logger, vendorRepository *tovendor.DDBRepository, results []patcher.Result, maxErrorRate float64) *report.Canary {
+	canary := &report.Canary{
+		Size:         len(results),
+		MaxErrorRate: maxErrorRate,
+		Mismatches:   []string{},
+	}
+
+	for _, result := range results {
+		if result.Status.IsFailed() {
+			canary.Errors++
+			continue
+		}
+
+		if result.Status != patcher.StatusUpdated || isDryRunFlag {
+			continue
+		}
+
+		vendor, err := vendorRepository.GetVendor(ctx, result.VendorCode)
+		if err != nil {
+			logger.Warn("Failed to verify canary vendor", "vendor_code", result.VendorCode, "error", err)
+			canary.Errors++
+			continue
+		}
+
+		if written := vendor.Attribute(result.Attribute); written != result.Proposed {
+			logger.Warn("Canary vendor has a value other than the proposed one", "vendor_code", result.VendorCode, "attribute", result.Attribute, "written", written, "proposed", result.Proposed)
+			canary.Errors++
+			canary.Mismatches = append(canary.Mismatches, result.VendorCode)
+		}
+	}
+
+	if canary.Size > 0 {
+		canary.ErrorRate = float64(canary.Errors) / float64(canary.Size) * 100
+	}
+
+	return canary
+}
+
+// promptMu serializes the canary prompts of the GEIDs patched in parallel, so that an answer is read for the
+// prompt printed last.
+var promptMu sync.Mutex
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirmRollout asks the operator whether to proceed with a canary over the error threshold.
+// It refuses when stdin is not a terminal.
+func confirmRollout(geid string, canary *report.Canary) bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	return askRollout(stdin, os.Stderr, geid, canary)
+}
+
+// askRollout prints the prompt of confirmRollout to out and reads the answer from in. Only the prompt holds
+// stdoutMu, the other GEIDs keep logging while the operator answers.
+func askRollout(in *bufio.Reader, out io.Writer, geid string, canary *report.Canary) bool {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	stdoutMu.Lock()
+	if canary.Size == 0 {
+		fmt.Fprintf(out, "Canary of %s verified no vendor, proceed with the rest? [y/N] ", geid)
+	} else {
+		fmt.Fprintf(out, "Canary of %s has error rate %.1f%% over %.1f%%, proceed with the rest? [y/N] ", geid, canary.ErrorRate, canary.MaxErrorRate)
+	}
+	stdoutMu.Unlock()
+
+	answer, err := in.ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,247 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+)
+
+const fileName = "checkpoint.jsonl"
+
+// Entry is the outcome of a vendor, a Page up to which all vendors of a GEID are completed, or the Header of the run.
+type Entry struct {
+	RunID      string    `json:"run_id"`
+	GEID       string    `json:"geid"`
+	VendorCode string    `json:"vendor_code"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	Page       *Page     `json:"page,omitempty"`
+	Header     *Header   `json:"header,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Header is what a run patches, a run can only be resumed to patch the same.
+type Header struct {
+	Target string `json:"target"`
+	Env    string `json:"env"`
+	// Spec is the digest of the spec of the target, it's empty when the target isn't declared by a spec.
+	Spec string `json:"spec,omitempty"`
+}
+
+func (h Header) String() string {
+	if h.Spec == "" {
+		return fmt.Sprintf("target %s in %s", h.Target, h.Env)
+	}
+	return fmt.Sprintf("target %s in %s with spec %s", h.Target, h.Env, h.Spec)
+}
+
+// Page is where the vendor query of a GEID resumes.
+type Page struct {
+	// Key is the LastEvaluatedKey of the page.
+	Key string `json:"key"`
+	// Selected is the number of vendors selected up to the page, it's counted against the limit of a resumed run.
+	Selected int `json:"selected"`
+}
+
+// Store is an append-only checkpoint of a run, it's safe for concurrent use.
+type Store struct {
+	mu        sync.Mutex
+	runID     string
+	file      *os.File
+	completed map[string]struct{}
+	pages     map[string]Page
+}
+
+// Open opens the checkpoint of runID under dir and loads the vendors completed so far. It fails when the checkpoint
+// is of another header, or is missing and mustExist is set.
+func Open(dir, runID string, header Header, mustExist bool, logger *slog.Logger) (*Store, error) {
+	path := filepath.Join(dir, fileName)
+
+	loaded, completed, pages, err := load(path, logger)
+	if errors.Is(err, fs.ErrNotExist) {
+		if mustExist {
+			return nil, fmt.Errorf("no checkpoint found for run %s at %s", runID, path)
+		}
+	} else if err != nil {
+		return nil, err
+	} else if loaded == nil {
+		logger.Warn("Checkpoint has no header, it can't be checked against the resumed run", "path", path)
+	} else if *loaded != header {
+		return nil, fmt.Errorf("checkpoint of run %s is for %s, it can't be resumed for %s", runID, loaded, header)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+
+	if completed == nil {
+		completed = make(map[string]struct{})
+		pages = make(map[string]Page)
+	}
+
+	s := &Store{
+		runID:     runID,
+		file:      file,
+		completed: completed,
+		pages:     pages,
+	}
+
+	if loaded == nil {
+		if err := s.write(Entry{RunID: runID, Header: &header, Time: time.Now().UTC()}); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// load reads the checkpoint at path, the returned header is nil when the checkpoint has none.
+func load(path string, logger *slog.Logger) (*Header, map[string]struct{}, map[string]Page, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+
+	var header *Header
+	completed := make(map[string]struct{})
+	pages := make(map[string]Page)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// the last line could be cut off when the process is killed while writing it.
+			logger.Warn("Skip malformed checkpoint entry", "entry", scanner.Text())
+			continue
+		}
+
+		if entry.Header != nil {
+			header = entry.Header
+			continue
+		}
+
+		if entry.Page != nil {
+			pages[entry.GEID] = *entry.Page
+			continue
+		}
+
+		k := key(entry.GEID, entry.VendorCode)
+		if patcher.Status(entry.Outcome).IsCompleted() {
+			completed[k] = struct{}{}
+		} else {
+			delete(completed, k)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return header, completed, pages, nil
+}
+
+func key(geid, vendorCode string) string {
+	return fmt.Sprintf("%s#%s", geid, vendorCode)
+}
+
+func (s *Store) IsCompleted(geid, vendorCode string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.completed[key(geid, vendorCode)]
+	return ok
+}
+
+// Record appends the outcome of a vendor.
+func (s *Store) Record(geid string, result patcher.Result) error {
+	entry := Entry{
+		RunID:      s.runID,
+		GEID:       geid,
+		VendorCode: result.VendorCode,
+		Outcome:    string(result.Status),
+		Time:       time.Now().UTC(),
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(entry); err != nil {
+		return err
+	}
+
+	k := key(geid, result.VendorCode)
+	if result.Status.IsCompleted() {
+		s.completed[k] = struct{}{}
+	} else {
+		delete(s.completed, k)
+	}
+
+	return nil
+}
+
+// Page returns where the vendor query of geid resumes, it's false when no page has been completed.
+func (s *Store) Page(geid string) (Page, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[geid]
+	return page, ok
+}
+
+// RecordPage appends the page up to which all vendors of geid are completed.
+func (s *Store) RecordPage(geid string, page Page) error {
+	entry := Entry{
+		RunID: s.runID,
+		GEID:  geid,
+		Page:  &page,
+		Time:  time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(entry); err != nil {
+		return err
+	}
+
+	s.pages[geid] = page
+	return nil
+}
+
+// write appends entry to the file, s.mu must be held.
+func (s *Store) write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint entry: %w", err)
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint entry: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
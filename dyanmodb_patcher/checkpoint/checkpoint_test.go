@@ -0,0 +1,81 @@
+package checkpoint
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+)
+
+func TestOpenChecksHeader(t *testing.T) {
+	header := Header{Target: "legal_name", Env: "staging", Spec: "0123456789abcdef"}
+
+	tests := []struct {
+		name    string
+		header  Header
+		wantErr bool
+	}{
+		{name: "same run", header: header},
+		{name: "other target", header: Header{Target: "local_legal_name", Env: "staging", Spec: header.Spec}, wantErr: true},
+		{name: "other env", header: Header{Target: "legal_name", Env: "production", Spec: header.Spec}, wantErr: true},
+		{name: "other spec", header: Header{Target: "legal_name", Env: "staging", Spec: "fedcba9876543210"}, wantErr: true},
+		{name: "no spec", header: Header{Target: "legal_name", Env: "staging"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := Open(dir, "run", header, false, slog.Default())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Record("FP_SG", patcher.Result{VendorCode: "v001", Status: patcher.StatusUpdated}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed, err := Open(dir, "run", tt.header, true, slog.Default())
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "can't be resumed") {
+					t.Errorf("resuming %s from %s: err = %v, want it refused", tt.header, header, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resumed.Close()
+			if !resumed.IsCompleted("FP_SG", "v001") {
+				t.Error("v001 is not completed in the resumed checkpoint")
+			}
+		})
+	}
+}
+
+func TestOpenWarnsMissingHeader(t *testing.T) {
+	dir := t.TempDir()
+	entry := `{"run_id":"run","geid":"FP_SG","vendor_code":"v001","outcome":"updated","time":"2026-10-18T00:00:00Z"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, fileName), []byte(entry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	store, err := Open(dir, "run", Header{Target: "legal_name", Env: "staging"}, true, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if !store.IsCompleted("FP_SG", "v001") {
+		t.Error("v001 is not completed in the checkpoint without header")
+	}
+	if !strings.Contains(logs.String(), "no header") {
+		t.Errorf("missing header is not warned: %s", logs.String())
+	}
+}
@@ -0,0 +1,231 @@
+package retryhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+)
+
+// Class tells how the caller should react to a failed request.
+type Class int
+
+const (
+	// ClassRetryable failures are transient, e.g. 502 or a timeout.
+	ClassRetryable Class = iota
+	// ClassPermanent failures won't succeed on retry but only affect this request, e.g. 404.
+	ClassPermanent
+	// ClassFatal failures affect every request, e.g. 401 for a bad token, so the run should be aborted.
+	ClassFatal
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassRetryable:
+		return "retryable"
+	case ClassPermanent:
+		return "permanent"
+	case ClassFatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// StatusError is returned for responses with a non-2xx status code.
+type StatusError struct {
+	StatusCode int
+	Class      Class
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d (%s): %s", e.StatusCode, e.Class, e.Body)
+}
+
+// ClassOf returns the class of err, errors not returned by Client are retryable.
+func ClassOf(err error) Class {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Class
+	}
+	return ClassRetryable
+}
+
+func IsFatal(err error) bool {
+	return err != nil && ClassOf(err) == ClassFatal
+}
+
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+func classify(statusCode int) Class {
+	switch {
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		return ClassFatal
+	case statusCode == http.StatusTooManyRequests, statusCode == http.StatusRequestTimeout, statusCode >= 500:
+		return ClassRetryable
+	}
+	return ClassPermanent
+}
+
+type Config struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Limiter throttles every attempt, retries included. It's nil when the downstream is not rate limited.
+	Limiter *ratelimit.Limiter
+}
+
+var DefaultConfig = Config{
+	MaxAttempts: 5,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// Observer is notified of every attempt of Client, e.g. to export metrics. statusCode is 0 when the attempt got no
+// response.
+type Observer interface {
+	ObserveAttempt(attempt, statusCode int, duration time.Duration)
+}
+
+// Client sends requests with retries on retryable failures, it's the HTTP layer shared by outbound clients.
+type Client struct {
+	httpClient *http.Client
+	cfg        Config
+	observer   Observer
+}
+
+func NewClient(httpClient *http.Client, cfg Config) *Client {
+	return &Client{
+		httpClient: httpClient,
+		cfg:        cfg,
+	}
+}
+
+// WithObserver returns a copy of c notifying observer, it shares the HTTP client and the limiter of c.
+func (c *Client) WithObserver(observer Observer) *Client {
+	clone := *c
+	clone.observer = observer
+	return &clone
+}
+
+// Do sends req until it gets a 2xx response, a non-retryable failure, or runs out of attempts.
+// A non-2xx response is returned as *StatusError with its body consumed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	var lastErr error
+	for attempt := 0; attempt < c.cfg.MaxAttempts; attempt++ {
+		if err := c.cfg.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		attemptReq, err := cloneRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		startedAt := time.Now()
+		response, err := c.httpClient.Do(attemptReq)
+		if c.observer != nil {
+			statusCode := 0
+			if err == nil {
+				statusCode = response.StatusCode
+			}
+			c.observer.ObserveAttempt(attempt, statusCode, time.Since(startedAt))
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				return response, nil
+			}
+
+			err = statusError(response)
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+		}
+
+		if ClassOf(err) != ClassRetryable || ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+
+		if attempt == c.cfg.MaxAttempts-1 {
+			break
+		}
+
+		// Retry-After is honored up to MaxDelay, a longer one would hold the worker for as long as it asks.
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = min(retryAfter, c.cfg.MaxDelay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil, fmt.Errorf("gave up after %d attempts: %w", c.cfg.MaxAttempts, lastErr)
+}
+
+// backoff returns a full jitter delay of the exponential backoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.cfg.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > c.cfg.MaxDelay {
+		ceiling = c.cfg.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.GetBody == nil {
+		return clone, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("unable to rewind request body: %w", err)
+	}
+	clone.Body = body
+	return clone, nil
+}
+
+func statusError(response *http.Response) error {
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return &StatusError{
+		StatusCode: response.StatusCode,
+		Class:      classify(response.StatusCode),
+		Body:       string(body),
+	}
+}
+
+// parseRetryAfter supports both delay-seconds and HTTP-date values of the Retry-After header.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
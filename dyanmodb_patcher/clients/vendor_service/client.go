@@ -1,30 +1,28 @@
 package vendorSrv
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
 	pdkit "github.com/deliveryhero/pd-go-kit"
 )
 
 type Client struct {
-	httpClient        *http.Client
+	httpClient        *retryhttp.Client
 	endpointFormatStr string
 	serviceToken      string
 	userEmail         string
 	globalEntity      utils.GlobalEntity
 }
 
-type vendorServiceResp struct {
-	AccountNameLocalized string `json:"account_name_localized"`
-}
-
-func NewClient(ge utils.GlobalEntity, cfg config.Config, httpClient *http.Client) *Client {
+func NewClient(ge utils.GlobalEntity, cfg config.Config, httpClient *retryhttp.Client) *Client {
 	var token = os.Getenv("VENDOR_SERVICE_TOKEN")
 	var email = os.Getenv("EMAIL")
 
@@ -48,12 +46,40 @@ func (c *Client) ValidateEnvConfig() error {
 	return nil
 }
 
-func (c *Client) GetLocalLegalName(vendorCode string) (string, error) {
-	req, err := http.NewRequest(
-		http.MethodGet, fmt.Sprintf(c.endpointFormatStr, c.globalEntity.CountryCode, vendorCode), nil,
+// GetField returns the value at the dot separated jsonPath of the vendor, e.g. "chain.name", or an empty string when
+// it's missing or null. Its error can be classified by retryhttp.ClassOf.
+func (c *Client) GetField(ctx context.Context, vendorCode, jsonPath string) (string, error) {
+	var resp map[string]interface{}
+	if err := c.getVendor(ctx, vendorCode, &resp); err != nil {
+		return "", err
+	}
+
+	var value interface{} = resp
+	for _, key := range strings.Split(jsonPath, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("field %s of vendor %s is not a scalar value", jsonPath, vendorCode)
+}
+
+func (c *Client) getVendor(ctx context.Context, vendorCode string, out interface{}) error {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, fmt.Sprintf(c.endpointFormatStr, c.globalEntity.CountryCode, vendorCode), nil,
 	)
 	if err != nil {
-		log.Fatalf("unable to make a new request: %w", err)
+		return fmt.Errorf("unable to make a new request: %w", err)
 	}
 
 	req.Header.Add("Accept", "application/json")
@@ -65,21 +91,14 @@ func (c *Client) GetLocalLegalName(vendorCode string) (string, error) {
 
 	response, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("unable to invoke vendor service: %w", err)
+		return fmt.Errorf("failed to get vendor from vendor service: %w", err)
 	}
 
 	defer response.Body.Close()
 
-	statusCode := response.StatusCode
-	if statusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get vendor from vendor service: %d, %v", statusCode, response)
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response: %w", err)
 	}
 
-	var resp vendorServiceResp
-	if err := json.NewDecoder(response.Body).Decode(&resp); err != nil {
-		return "", fmt.Errorf("unable to decode response: %w", err)
-	}
-
-	// account_name_localized from vendor service = local legal name we pass to cybersource
-	return resp.AccountNameLocalized, nil
+	return nil
 }
@@ -0,0 +1,211 @@
+// Package vendorsrvtest is a local stand-in of vendor service built on httptest, it serves vendors from fixtures
+// and injects faults so that the patcher can be tested end to end.
+package vendorsrvtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pdkit "github.com/deliveryhero/pd-go-kit"
+)
+
+// vendorPath is the path of a vendor under the server URL, the country code stands for the subdomain of the
+// real endpoint.
+const vendorPath = "/%s/api/v1/vendor-service/vendors/%s"
+
+// Fault is injected into the responses of a vendor.
+type Fault struct {
+	// Status responds with the status code instead of the vendor when it's not 0.
+	Status int
+	// RetryAfter is the Retry-After header of the Status response when it's not empty.
+	RetryAfter string
+	// Delay holds the response, it's longer than the client timeout to simulate a slow vendor service.
+	Delay time.Duration
+	// MalformedJSON responds with a body that can't be decoded.
+	MalformedJSON bool
+	// Times is the number of requests the fault is injected into, 0 means every request.
+	Times int
+}
+
+// Server is a fake vendor service, it's safe for concurrent use.
+type Server struct {
+	*httptest.Server
+	token string
+
+	mu       sync.Mutex
+	vendors  map[string]map[string]interface{}
+	faults   map[string]*Fault
+	requests map[string]int
+}
+
+// NewServer starts a server accepting token as the bearer token, it should be closed by the caller.
+func NewServer(token string) *Server {
+	s := &Server{
+		token:    token,
+		vendors:  make(map[string]map[string]interface{}),
+		faults:   make(map[string]*Fault),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// EndpointFormatStr is the config.VendorService endpoint of the server.
+func (s *Server) EndpointFormatStr() string {
+	return s.URL + vendorPath
+}
+
+// SetVendor serves body as the vendor of geid.
+func (s *Server) SetVendor(geid, vendorCode string, body map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.vendors[key(geid, vendorCode)] = body
+}
+
+// SetFault injects fault into the responses of the vendor of geid.
+func (s *Server) SetFault(geid, vendorCode string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults[key(geid, vendorCode)] = &fault
+}
+
+// LoadFixture serves the vendors of a JSON file mapping GEIDs to vendor codes to vendor bodies, e.g.
+//
+//	{"FP_SG": {"a1b2": {"account_name_localized": "Dine-in Cafe Pte. Ltd."}}}
+func (s *Server) LoadFixture(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read vendor fixture: %w", err)
+	}
+
+	var fixture map[string]map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return fmt.Errorf("invalid vendor fixture %s: %w", path, err)
+	}
+
+	for geid, vendors := range fixture {
+		for code, body := range vendors {
+			s.SetVendor(geid, code, body)
+		}
+	}
+	return nil
+}
+
+// Requests returns the number of requests for the vendor of geid, including the rejected ones.
+func (s *Server) Requests(geid, vendorCode string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[key(geid, vendorCode)]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	countryCode, vendorCode, ok := parseVendorPath(r.URL.Path)
+	if !ok || r.Method != http.MethodGet {
+		http.Error(w, `{"message": "not found"}`, http.StatusNotFound)
+		return
+	}
+
+	geid := r.Header.Get(pdkit.HeaderAPIGlobalEntityID)
+
+	s.mu.Lock()
+	s.requests[key(geid, vendorCode)]++
+	fault := s.takeFault(geid, vendorCode)
+	body, isKnown := s.vendors[key(geid, vendorCode)]
+	s.mu.Unlock()
+
+	if r.Header.Get(pdkit.HeaderAPIOAuthToken) != "Bearer "+s.token {
+		http.Error(w, `{"message": "invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if err := checkHeaders(r, geid, countryCode); err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q}`, err), http.StatusBadRequest)
+		return
+	}
+
+	if fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			http.Error(w, fmt.Sprintf(`{"message": "injected %d"}`, fault.Status), fault.Status)
+			return
+		}
+		if fault.MalformedJSON {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"account_name_localized": `)
+			return
+		}
+	}
+
+	if !isKnown {
+		http.Error(w, `{"message": "vendor not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// parseVendorPath returns the country code and the vendor code of a vendorPath.
+func parseVendorPath(path string) (string, string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 6 || strings.Join(parts[1:5], "/") != "api/v1/vendor-service/vendors" {
+		return "", "", false
+	}
+	return parts[0], parts[5], true
+}
+
+// takeFault returns the fault of the vendor and counts the request against it, s.mu must be held.
+func (s *Server) takeFault(geid, vendorCode string) *Fault {
+	fault, ok := s.faults[key(geid, vendorCode)]
+	if !ok {
+		return nil
+	}
+
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			delete(s.faults, key(geid, vendorCode))
+		}
+	}
+	return fault
+}
+
+// checkHeaders checks the headers vendor service requires besides the token.
+func checkHeaders(r *http.Request, geid, countryCode string) error {
+	globalEntity, ok := pdkit.GlobalEntities[geid]
+	if !ok {
+		return fmt.Errorf("unknown %s %q", pdkit.HeaderAPIGlobalEntityID, geid)
+	}
+	if globalEntity.CountryCode != countryCode {
+		return fmt.Errorf("%s %s doesn't match country %s", pdkit.HeaderAPIGlobalEntityID, geid, countryCode)
+	}
+
+	for _, header := range []string{"X-Pandora-Username", pdkit.HeaderPerseusClientID, pdkit.HeaderPerseusSessionID} {
+		if r.Header.Get(header) == "" {
+			return fmt.Errorf("%s header is required", header)
+		}
+	}
+	return nil
+}
+
+func key(geid, vendorCode string) string {
+	return fmt.Sprintf("%s#%s", geid, vendorCode)
+}
@@ -1,58 +1,175 @@
 package config
 
 import (
+	_ "embed"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+	"gopkg.in/yaml.v3"
 )
 
+// DefaultTimeout is the timeout of a vendor service request when the environment doesn't set one.
+const DefaultTimeout = 10 * time.Second
+
+// Environment variables overriding the config of the selected environment.
+const (
+	envRegion            = "PATCHER_AWS_REGION"
+	envProfile           = "PATCHER_AWS_PROFILE"
+	envTableName         = "PATCHER_DYNAMODB_TABLE_NAME"
+	envDynamoDBEndpoint  = "PATCHER_DYNAMODB_ENDPOINT"
+	envDynamoDBTimeout   = "PATCHER_DYNAMODB_TIMEOUT"
+	envVendorSrvEndpoint = "PATCHER_VENDOR_SERVICE_ENDPOINT_FORMAT"
+	envVendorSrvTimeout  = "PATCHER_VENDOR_SERVICE_TIMEOUT"
+	envGEIDs             = "PATCHER_GEIDS"
+)
+
+//go:embed environments.yaml
+var builtinEnvironments []byte
+
 type Config struct {
-	AWS
-	VendorService
+	// Env is the name of the environment, e.g. staging.
+	Env           string `yaml:"-"`
+	AWS           `yaml:"aws"`
+	VendorService `yaml:"vendor_service"`
+	// GEIDs are the entities patched by all flag.
+	GEIDs []string `yaml:"geids"`
 }
 
 type AWS struct {
-	Region            string
-	Profile           string
-	DynamoDBTableName string
+	Region            string `yaml:"region"`
+	Profile           string `yaml:"profile"`
+	DynamoDBTableName string `yaml:"dynamodb_table_name"`
+	// DynamoDBEndpoint replaces the endpoint of the region when it's set, e.g. http://localhost:8000 for DynamoDB
+	// local.
+	DynamoDBEndpoint string `yaml:"dynamodb_endpoint"`
+	// DynamoDBTimeout is the timeout of a DynamoDB request, 0 leaves it to the SDK.
+	DynamoDBTimeout time.Duration `yaml:"dynamodb_timeout"`
 }
 
 type VendorService struct {
-	EndpointFormatStr string
-}
-
-var prodConfig = Config{
-	AWS: AWS{
-		Region:            "ap-southeast-1",
-		Profile:           "pd-production",
-		DynamoDBTableName: "asia-prod-table-ordering",
-	},
-	VendorService: VendorService{
-		EndpointFormatStr: "https://%s.fd-api.com/api/v1/vendor-service/vendors/%s",
-	},
-}
-
-var stagingConfig = Config{
-	AWS: AWS{
-		Region:            "eu-central-1",
-		Profile:           "pd-staging",
-		DynamoDBTableName: "asia-staging-table-ordering",
-	},
-	VendorService: VendorService{
-		EndpointFormatStr: "https://%s-st.fd-api.com/api/v1/vendor-service/vendors/%s",
-	},
-}
-
-func GetByEnv(env utils.Env) (Config, error) {
-	switch env {
-	case utils.EnvStaging:
-		{
-			return stagingConfig, nil
+	// EndpointFormatStr is formatted with the country code and the vendor code.
+	EndpointFormatStr string        `yaml:"endpoint_format"`
+	Timeout           time.Duration `yaml:"timeout"`
+}
+
+// Environments maps the name of an environment to its config.
+type Environments map[string]Config
+
+// Load returns the built-in environments, overlaid with the environments of the YAML file at path when it's not
+// empty. An environment of the file replaces the built-in one of the same name as a whole.
+func Load(path string) (Environments, error) {
+	envs := make(Environments)
+	if err := yaml.Unmarshal(builtinEnvironments, &envs); err != nil {
+		return nil, fmt.Errorf("invalid built-in environments: %w", err)
+	}
+
+	if path == "" {
+		return envs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var overlay Environments
+	if err := yaml.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	for name, cfg := range overlay {
+		envs[name] = cfg
+	}
+
+	return envs, nil
+}
+
+// Get returns the config of env with the overrides of the PATCHER_* environment variables applied.
+func (e Environments) Get(env string) (Config, error) {
+	cfg, ok := e[env]
+	if !ok {
+		return Config{}, fmt.Errorf("invalid env %s, it should be one of %s", env, strings.Join(e.names(), ", "))
+	}
+	cfg.Env = env
+
+	if err := cfg.applyOverrides(); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.VendorService.Timeout == 0 {
+		cfg.VendorService.Timeout = DefaultTimeout
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config of env %s: %w", env, err)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) applyOverrides() error {
+	for name, field := range map[string]*string{
+		envRegion:            &cfg.AWS.Region,
+		envProfile:           &cfg.AWS.Profile,
+		envTableName:         &cfg.AWS.DynamoDBTableName,
+		envDynamoDBEndpoint:  &cfg.AWS.DynamoDBEndpoint,
+		envVendorSrvEndpoint: &cfg.VendorService.EndpointFormatStr,
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
 		}
-	case utils.EnvProd:
-		{
-			return prodConfig, nil
+	}
+
+	for name, field := range map[string]*time.Duration{
+		envDynamoDBTimeout:  &cfg.AWS.DynamoDBTimeout,
+		envVendorSrvTimeout: &cfg.VendorService.Timeout,
+	} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = d
+	}
+
+	if value, ok := os.LookupEnv(envGEIDs); ok {
+		cfg.GEIDs = nil
+		for _, geid := range strings.Split(value, ",") {
+			if geid = strings.TrimSpace(geid); geid != "" {
+				cfg.GEIDs = append(cfg.GEIDs, geid)
+			}
 		}
 	}
-	return Config{}, fmt.Errorf("Invalid Env")
+
+	return nil
+}
+
+func (cfg Config) validate() error {
+	if cfg.AWS.Region == "" {
+		return fmt.Errorf("aws.region is required")
+	}
+	if cfg.AWS.DynamoDBTableName == "" {
+		return fmt.Errorf("aws.dynamodb_table_name is required")
+	}
+	if strings.Count(cfg.VendorService.EndpointFormatStr, "%s") != 2 {
+		return fmt.Errorf("vendor_service.endpoint_format should have a %%s for the country code and one for the vendor code")
+	}
+	if cfg.AWS.DynamoDBTimeout < 0 || cfg.VendorService.Timeout < 0 {
+		return fmt.Errorf("timeouts can't be negative")
+	}
+	return nil
+}
+
+func (e Environments) names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
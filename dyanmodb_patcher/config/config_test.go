@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadBuiltin(t *testing.T) {
+	envs, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := envs.Get("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != "prod" || cfg.AWS.DynamoDBTableName != "asia-prod-table-ordering" || cfg.VendorService.Timeout != 10*time.Second {
+		t.Errorf("unexpected prod config %+v", cfg)
+	}
+	if len(cfg.GEIDs) != 8 {
+		t.Errorf("expected the 8 GEIDs of prod, got %v", cfg.GEIDs)
+	}
+
+	if _, err := envs.Get("loadtest"); err == nil {
+		t.Error("expected an error for an unknown env")
+	}
+}
+
+func TestLoadOverlayAndOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "environments.yaml")
+	err := os.WriteFile(path, []byte(`
+loadtest:
+  aws:
+    region: ap-southeast-1
+    dynamodb_table_name: asia-loadtest-table-ordering
+    dynamodb_endpoint: http://localhost:8000
+    dynamodb_timeout: 2s
+  vendor_service:
+    endpoint_format: http://localhost:8080/%s/vendors/%s
+  geids: [FP_SG]
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envs, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := envs["staging"]; !ok {
+		t.Error("expected the built-in staging to be kept")
+	}
+
+	t.Setenv(envTableName, "asia-loadtest-table-ordering-2")
+	t.Setenv(envVendorSrvTimeout, "3s")
+	t.Setenv(envGEIDs, "FP_SG, FP_TW")
+
+	cfg, err := envs.Get("loadtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Config{
+		Env: "loadtest",
+		AWS: AWS{
+			Region:            "ap-southeast-1",
+			DynamoDBTableName: "asia-loadtest-table-ordering-2",
+			DynamoDBEndpoint:  "http://localhost:8000",
+			DynamoDBTimeout:   2 * time.Second,
+		},
+		VendorService: VendorService{
+			EndpointFormatStr: "http://localhost:8080/%s/vendors/%s",
+			Timeout:           3 * time.Second,
+		},
+		GEIDs: []string{"FP_SG", "FP_TW"},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+
+	t.Setenv(envVendorSrvEndpoint, "http://localhost:8080/vendors")
+	if _, err := envs.Get("loadtest"); err == nil {
+		t.Error("expected an error for an endpoint format without placeholders")
+	}
+}
@@ -2,8 +2,15 @@ package dynamodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -14,21 +21,49 @@ import (
 
 type DDBClient interface {
 	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
 	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// ErrItemNotFound is returned by GetItem when the key doesn't exist.
+var ErrItemNotFound = errors.New("item not found")
+
+// Retries of unprocessed batch items and conflicting transactions.
+const (
+	maxBatchAttempts = 8
+	batchBaseDelay   = 50 * time.Millisecond
+	batchMaxDelay    = 5 * time.Second
+)
+
+// Observer is notified of every write request a Client sends, e.g. to export metrics. retries counts the attempts
+// of the SDK after the first one, and the retry of unprocessed items or conflicting transactions.
+type Observer interface {
+	ObserveWrite(operation string, duration time.Duration, consumedCapacity float64, retries int, err error)
 }
 
 type Client struct {
 	ddbClient DDBClient
+	observer  Observer
 }
 
-var distinctClients = make(map[string]*Client)
+// distinctClients are shared by the GEIDs patched in parallel, distinctClientsMu guards them.
+var (
+	distinctClientsMu sync.Mutex
+	distinctClients   = make(map[string]*Client)
+)
 
-func distinctClientKey(region, profile string) string {
-	return fmt.Sprintf("%s#%s", region, profile)
+func distinctClientKey(awsCfg appConfig.AWS) string {
+	return fmt.Sprintf("%s#%s#%s#%s", awsCfg.Region, awsCfg.Profile, awsCfg.DynamoDBEndpoint, awsCfg.DynamoDBTimeout)
 }
 
 func NewClient(awsCfg appConfig.AWS) (*Client, error) {
-	key := distinctClientKey(awsCfg.Region, awsCfg.Profile)
+	key := distinctClientKey(awsCfg)
+
+	distinctClientsMu.Lock()
+	defer distinctClientsMu.Unlock()
 
 	if client, ok := distinctClients[key]; ok {
 		return client, nil
@@ -38,19 +73,88 @@ func NewClient(awsCfg appConfig.AWS) (*Client, error) {
 		config.WithRegion(awsCfg.Region),
 		config.WithSharedConfigProfile(awsCfg.Profile),
 	}
+	if awsCfg.DynamoDBTimeout > 0 {
+		options = append(options, config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(awsCfg.DynamoDBTimeout)))
+	}
 
 	cfg, err := config.LoadDefaultConfig(context.Background(), options...)
 	if err != nil {
 		return nil, err
 	}
 
-	ddbClient := dynamodb.NewFromConfig(cfg)
+	ddbClient := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if awsCfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(awsCfg.DynamoDBEndpoint)
+		}
+	})
 	client := &Client{ddbClient: ddbClient}
 	distinctClients[key] = client
 
 	return client, nil
 }
 
+// NewClientFrom returns a Client on ddbClient, e.g. the in-memory DynamoDB of the memory package.
+func NewClientFrom(ddbClient DDBClient) *Client {
+	return &Client{ddbClient: ddbClient}
+}
+
+// WithObserver returns a copy of c notifying observer of its writes, the copy requests the consumed capacity.
+func (c *Client) WithObserver(observer Observer) *Client {
+	clone := *c
+	clone.observer = observer
+	return &clone
+}
+
+// QueryPage reads a single page of the query starting at in.ExclusiveStartKey and unmarshals its items to out.
+// It returns the LastEvaluatedKey of the page, which is nil for the last page.
+func (c *Client) QueryPage(ctx context.Context, in *dynamodb.QueryInput, out interface{}) (map[string]types.AttributeValue, error) {
+	response, err := c.ddbClient.Query(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("fail to Query ddb: %w", err)
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(response.Items, out); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ddb items: %w", err)
+	}
+
+	return response.LastEvaluatedKey, nil
+}
+
+// ScanPage reads a single page of the scan starting at in.ExclusiveStartKey and unmarshals its items to out, like
+// QueryPage does.
+func (c *Client) ScanPage(ctx context.Context, in *dynamodb.ScanInput, out interface{}) (map[string]types.AttributeValue, error) {
+	response, err := c.ddbClient.Scan(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("fail to Scan ddb: %w", err)
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(response.Items, out); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ddb items: %w", err)
+	}
+
+	return response.LastEvaluatedKey, nil
+}
+
+// QueryCount returns the number of items matched by the query across all its pages, the items themselves are not
+// returned by DynamoDB. in must not have a projection.
+func (c *Client) QueryCount(ctx context.Context, in *dynamodb.QueryInput) (int, error) {
+	in.Select = types.SelectCount
+
+	count := 0
+	for {
+		response, err := c.ddbClient.Query(ctx, in)
+		if err != nil {
+			return 0, fmt.Errorf("fail to Query ddb: %w", err)
+		}
+
+		count += int(response.Count)
+		if response.LastEvaluatedKey == nil {
+			return count, nil
+		}
+		in.ExclusiveStartKey = response.LastEvaluatedKey
+	}
+}
+
 func (c *Client) QueryAll(ctx context.Context, in *dynamodb.QueryInput, out interface{}) error {
 	var allItems []map[string]types.AttributeValue
 	var lastEvaluatedKey map[string]types.AttributeValue
@@ -80,8 +184,25 @@ func (c *Client) QueryAll(ctx context.Context, in *dynamodb.QueryInput, out inte
 }
 
 func (c *Client) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, out interface{}) error {
-	var output *dynamodb.UpdateItemOutput
+	if c.observer != nil {
+		input := *in
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		in = &input
+	}
+
+	startedAt := time.Now()
 	output, err := c.ddbClient.UpdateItem(ctx, in)
+	if c.observer != nil {
+		var capacity []types.ConsumedCapacity
+		retries := 0
+		if output != nil {
+			if output.ConsumedCapacity != nil {
+				capacity = append(capacity, *output.ConsumedCapacity)
+			}
+			retries = sdkRetries(retry.GetAttemptResults(output.ResultMetadata))
+		}
+		c.observer.ObserveWrite("UpdateItem", time.Since(startedAt), capacityUnits(capacity), retries, err)
+	}
 
 	if err != nil {
 		return err
@@ -94,3 +215,139 @@ func (c *Client) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, o
 	err = attributevalue.UnmarshalMap(output.Attributes, out)
 	return err
 }
+
+func (c *Client) GetItem(ctx context.Context, in *dynamodb.GetItemInput, out interface{}) error {
+	output, err := c.ddbClient.GetItem(ctx, in)
+	if err != nil {
+		return fmt.Errorf("fail to GetItem ddb: %w", err)
+	}
+
+	if output == nil || output.Item == nil {
+		return ErrItemNotFound
+	}
+
+	return attributevalue.UnmarshalMap(output.Item, out)
+}
+
+// BatchGetItem reads the items of in with retries of the unprocessed keys, it returns the items of all tables.
+// Keys that don't exist are not returned.
+func (c *Client) BatchGetItem(ctx context.Context, in *dynamodb.BatchGetItemInput) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	requestItems := in.RequestItems
+
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt == maxBatchAttempts {
+			return nil, fmt.Errorf("%v keys are still unprocessed after %v attempts of BatchGetItem", countKeys(requestItems), attempt)
+		}
+		if err := sleepBackoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+
+		response, err := c.ddbClient.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			return nil, fmt.Errorf("fail to BatchGetItem ddb: %w", err)
+		}
+
+		for _, tableItems := range response.Responses {
+			items = append(items, tableItems...)
+		}
+		requestItems = response.UnprocessedKeys
+	}
+
+	return items, nil
+}
+
+// TransactWriteItems writes the items of in all or nothing. A transaction cancelled by a conflict with another
+// transaction is retried, other cancellations are returned as *types.TransactionCanceledException.
+func (c *Client) TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput) error {
+	for attempt := 0; ; attempt++ {
+		if err := sleepBackoff(ctx, attempt); err != nil {
+			return err
+		}
+
+		input := *in
+		input.ReturnConsumedCapacity = c.returnConsumedCapacity()
+		startedAt := time.Now()
+		response, err := c.ddbClient.TransactWriteItems(ctx, &input)
+		if c.observer != nil {
+			var capacity []types.ConsumedCapacity
+			retries := min(attempt, 1)
+			if response != nil {
+				capacity = response.ConsumedCapacity
+				retries += sdkRetries(retry.GetAttemptResults(response.ResultMetadata))
+			}
+			c.observer.ObserveWrite("TransactWriteItems", time.Since(startedAt), capacityUnits(capacity), retries, err)
+		}
+
+		var canceledErr *types.TransactionCanceledException
+		if errors.As(err, &canceledErr) && isTransactionConflict(canceledErr) && attempt+1 < maxBatchAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// returnConsumedCapacity asks for the consumed capacity when it's observed.
+func (c *Client) returnConsumedCapacity() types.ReturnConsumedCapacity {
+	if c.observer == nil {
+		return ""
+	}
+	return types.ReturnConsumedCapacityTotal
+}
+
+// sdkRetries returns the retries of the SDK from the attempt results of a response.
+func sdkRetries(results retry.AttemptResults, ok bool) int {
+	if !ok || len(results.Results) == 0 {
+		return 0
+	}
+	return len(results.Results) - 1
+}
+
+func capacityUnits(capacity []types.ConsumedCapacity) float64 {
+	var units float64
+	for _, c := range capacity {
+		if c.CapacityUnits != nil {
+			units += *c.CapacityUnits
+		}
+	}
+	return units
+}
+
+func isTransactionConflict(err *types.TransactionCanceledException) bool {
+	for _, reason := range err.CancellationReasons {
+		if reason.Code != nil && *reason.Code == "TransactionConflict" {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepBackoff waits before the retry attempt with full jitter, the first attempt doesn't wait.
+func sleepBackoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return nil
+	}
+
+	delay := batchBaseDelay << (attempt - 1)
+	if delay > batchMaxDelay || delay <= 0 {
+		delay = batchMaxDelay
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(delay) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func countKeys(requestItems map[string]types.KeysAndAttributes) int {
+	count := 0
+	for _, keys := range requestItems {
+		count += len(keys.Keys)
+	}
+	return count
+}
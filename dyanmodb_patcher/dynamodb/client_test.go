@@ -0,0 +1,45 @@
+package dynamodb
+
+import (
+	"sync"
+	"testing"
+
+	appConfig "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+)
+
+func TestNewClientIsSharedByParallelCallers(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	awsCfgs := []appConfig.AWS{
+		{Region: "ap-southeast-1", DynamoDBEndpoint: "http://localhost:8000"},
+		{Region: "eu-west-1", DynamoDBEndpoint: "http://localhost:8000"},
+	}
+
+	// the GEIDs of a run create their clients in parallel.
+	clients := make([]*Client, 16)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client, err := NewClient(awsCfgs[i%len(awsCfgs)])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+
+	for i, client := range clients {
+		if client != clients[i%len(awsCfgs)] {
+			t.Errorf("client %v is not shared with the other clients of %+v", i, awsCfgs[i%len(awsCfgs)])
+		}
+	}
+	if clients[0] == clients[1] {
+		t.Error("clients of distinct configs are shared")
+	}
+}
@@ -0,0 +1,649 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// item is a DynamoDB item, it's never mutated once stored so that it can be shared by reads.
+type item = map[string]types.AttributeValue
+
+// operand is an attribute path or an expression attribute value, paths are top-level attributes only.
+type operand struct {
+	path  string
+	value types.AttributeValue
+}
+
+func (o operand) eval(it item) (types.AttributeValue, bool) {
+	if o.value != nil {
+		return o.value, true
+	}
+	value, ok := it[o.path]
+	return value, ok
+}
+
+// condition is a parsed condition, filter or key condition expression.
+type condition interface {
+	eval(it item) bool
+}
+
+type andCondition struct{ left, right condition }
+type orCondition struct{ left, right condition }
+type notCondition struct{ cond condition }
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+type between struct {
+	value, low, high operand
+}
+
+type function struct {
+	name string
+	args []operand
+}
+
+func (c andCondition) eval(it item) bool { return c.left.eval(it) && c.right.eval(it) }
+func (c orCondition) eval(it item) bool  { return c.left.eval(it) || c.right.eval(it) }
+func (c notCondition) eval(it item) bool { return !c.cond.eval(it) }
+
+// eval compares the operands like DynamoDB does, a comparison with an absent attribute is false except <>.
+func (c comparison) eval(it item) bool {
+	left, okLeft := c.left.eval(it)
+	right, okRight := c.right.eval(it)
+	if !okLeft || !okRight {
+		return c.op == "<>"
+	}
+
+	if c.op == "=" || c.op == "<>" {
+		return equal(left, right) == (c.op == "=")
+	}
+
+	cmp, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func (c between) eval(it item) bool {
+	value, ok := c.value.eval(it)
+	if !ok {
+		return false
+	}
+	low, okLow := c.low.eval(it)
+	high, okHigh := c.high.eval(it)
+	if !okLow || !okHigh {
+		return false
+	}
+
+	cmpLow, okLow := compare(value, low)
+	cmpHigh, okHigh := compare(value, high)
+	return okLow && okHigh && cmpLow >= 0 && cmpHigh <= 0
+}
+
+func (f function) eval(it item) bool {
+	switch f.name {
+	case "attribute_exists":
+		_, ok := f.args[0].eval(it)
+		return ok
+	case "attribute_not_exists":
+		_, ok := f.args[0].eval(it)
+		return !ok
+	case "begins_with":
+		value, okValue := f.args[0].eval(it)
+		prefix, okPrefix := f.args[1].eval(it)
+		if !okValue || !okPrefix {
+			return false
+		}
+		if s, ok := value.(*types.AttributeValueMemberS); ok {
+			p, ok := prefix.(*types.AttributeValueMemberS)
+			return ok && strings.HasPrefix(s.Value, p.Value)
+		}
+		if b, ok := value.(*types.AttributeValueMemberB); ok {
+			p, ok := prefix.(*types.AttributeValueMemberB)
+			return ok && bytes.HasPrefix(b.Value, p.Value)
+		}
+		return false
+	default: // contains
+		value, okValue := f.args[0].eval(it)
+		operand, okOperand := f.args[1].eval(it)
+		if !okValue || !okOperand {
+			return false
+		}
+		return contains(value, operand)
+	}
+}
+
+// update is a parsed update expression.
+type update struct {
+	set    []setAction
+	remove []string
+}
+
+type setAction struct {
+	path string
+	// value is the operand to set, ifNotExists keeps the current value of path when it exists.
+	value       operand
+	ifNotExists bool
+}
+
+// apply returns a copy of it with the update applied and the names of the updated attributes.
+func (u update) apply(it item) (item, []string) {
+	updated := make(item, len(it)+len(u.set))
+	for name, value := range it {
+		updated[name] = value
+	}
+
+	var names []string
+	for _, action := range u.set {
+		names = append(names, action.path)
+		if _, ok := it[action.path]; ok && action.ifNotExists {
+			continue
+		}
+		if value, ok := action.value.eval(it); ok {
+			updated[action.path] = value
+		}
+	}
+
+	for _, path := range u.remove {
+		names = append(names, path)
+		delete(updated, path)
+	}
+
+	return updated, names
+}
+
+// parser parses the subset of expressions the expression package of the AWS SDK generates for us, on top-level
+// attributes only.
+type parser struct {
+	tokens []string
+	pos    int
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newParser(expr string, names map[string]string, values map[string]types.AttributeValue) (*parser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens, names: names, values: values}, nil
+}
+
+func parseCondition(expr *string, names map[string]string, values map[string]types.AttributeValue) (condition, error) {
+	if expr == nil || *expr == "" {
+		return nil, nil
+	}
+
+	p, err := newParser(*expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q in %q", p.peek(), *expr)
+	}
+	return cond, nil
+}
+
+func parseProjection(expr *string, names map[string]string) ([]string, error) {
+	if expr == nil || *expr == "" {
+		return nil, nil
+	}
+
+	p, err := newParser(*expr, names, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for {
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+
+		if p.done() {
+			return paths, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseUpdate(expr *string, names map[string]string, values map[string]types.AttributeValue) (update, error) {
+	var u update
+	if expr == nil || *expr == "" {
+		return u, fmt.Errorf("update expression is required")
+	}
+
+	p, err := newParser(*expr, names, values)
+	if err != nil {
+		return u, err
+	}
+
+	for !p.done() {
+		clause := strings.ToUpper(p.next())
+		switch clause {
+		case "SET":
+			for {
+				action, err := p.setAction()
+				if err != nil {
+					return u, err
+				}
+				u.set = append(u.set, action)
+				if p.peek() != "," {
+					break
+				}
+				p.next()
+			}
+		case "REMOVE":
+			for {
+				path, err := p.path()
+				if err != nil {
+					return u, err
+				}
+				u.remove = append(u.remove, path)
+				if p.peek() != "," {
+					break
+				}
+				p.next()
+			}
+		default:
+			return u, fmt.Errorf("unsupported update clause %q in %q", clause, *expr)
+		}
+	}
+
+	return u, nil
+}
+
+func (p *parser) setAction() (setAction, error) {
+	path, err := p.path()
+	if err != nil {
+		return setAction{}, err
+	}
+	if err := p.expect("="); err != nil {
+		return setAction{}, err
+	}
+
+	if strings.EqualFold(p.peek(), "if_not_exists") {
+		p.next()
+		args, err := p.args()
+		if err != nil {
+			return setAction{}, err
+		}
+		if len(args) != 2 || args[0].path != path {
+			return setAction{}, fmt.Errorf("if_not_exists should take the path being set and a value")
+		}
+		return setAction{path: path, value: args[1], ifNotExists: true}, nil
+	}
+
+	value, err := p.operand()
+	if err != nil {
+		return setAction{}, err
+	}
+	if op := p.peek(); op == "+" || op == "-" {
+		return setAction{}, fmt.Errorf("arithmetic in update expressions is not supported")
+	}
+	return setAction{path: path, value: value}, nil
+}
+
+func (p *parser) or() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (condition, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (condition, error) {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.next()
+		cond, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{cond}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (condition, error) {
+	if p.peek() == "(" {
+		p.next()
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
+
+	switch name := strings.ToLower(p.peek()); name {
+	case "attribute_exists", "attribute_not_exists", "begins_with", "contains":
+		p.next()
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+
+		arity := 2
+		if name == "attribute_exists" || name == "attribute_not_exists" {
+			arity = 1
+		}
+		if len(args) != arity || args[0].path == "" {
+			return nil, fmt.Errorf("%s should take a path and %v arguments", name, arity)
+		}
+		return function{name: name, args: args}, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch {
+	case strings.EqualFold(op, "BETWEEN"):
+		low, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return between{left, low, high}, nil
+	case op == "=" || op == "<>" || op == "<" || op == "<=" || op == ">" || op == ">=":
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return comparison{op: op, left: left, right: right}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q after an operand", op)
+}
+
+func (p *parser) args() ([]operand, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []operand
+	for {
+		arg, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.peek() == ")" {
+			p.next()
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) operand() (operand, error) {
+	token := p.peek()
+	if strings.HasPrefix(token, ":") {
+		p.next()
+		value, ok := p.values[token]
+		if !ok {
+			return operand{}, fmt.Errorf("expression attribute value %s is not defined", token)
+		}
+		return operand{value: value}, nil
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{path: path}, nil
+}
+
+func (p *parser) path() (string, error) {
+	token := p.next()
+	if token == "" || !isName(token) {
+		return "", fmt.Errorf("expected an attribute name instead of %q", token)
+	}
+
+	if strings.HasPrefix(token, "#") {
+		name, ok := p.names[token]
+		if !ok {
+			return "", fmt.Errorf("expression attribute name %s is not defined", token)
+		}
+		token = name
+	}
+
+	if next := p.peek(); next == "." || next == "[" {
+		return "", fmt.Errorf("nested attribute paths are not supported")
+	}
+	return token, nil
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	if !p.done() {
+		p.pos++
+	}
+	return token
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("expected %q instead of %q", token, next)
+	}
+	return nil
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if next := p.next(); !strings.EqualFold(next, keyword) {
+		return fmt.Errorf("expected %s instead of %q", keyword, next)
+	}
+	return nil
+}
+
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("(),.[]+-", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '<' || r == '>':
+			j := i + 1
+			if j < len(runes) && (runes[j] == '=' || (r == '<' && runes[j] == '>')) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case r == '=':
+			tokens = append(tokens, "=")
+			i++
+		case r == '#' || r == ':' || isNameRune(r):
+			j := i + 1
+			for j < len(runes) && isNameRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in %q", r, expr)
+		}
+	}
+
+	return tokens, nil
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isName(token string) bool {
+	if strings.HasPrefix(token, "#") {
+		return len(token) > 1
+	}
+	for _, r := range token {
+		if !isNameRune(r) {
+			return false
+		}
+	}
+	return !strings.HasPrefix(token, ":")
+}
+
+func equal(a, b types.AttributeValue) bool {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		b, ok := b.(*types.AttributeValueMemberS)
+		return ok && a.Value == b.Value
+	case *types.AttributeValueMemberN:
+		cmp, ok := compare(a, b)
+		return ok && cmp == 0
+	case *types.AttributeValueMemberB:
+		b, ok := b.(*types.AttributeValueMemberB)
+		return ok && bytes.Equal(a.Value, b.Value)
+	case *types.AttributeValueMemberBOOL:
+		b, ok := b.(*types.AttributeValueMemberBOOL)
+		return ok && a.Value == b.Value
+	case *types.AttributeValueMemberNULL:
+		_, ok := b.(*types.AttributeValueMemberNULL)
+		return ok
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two scalars of the same type, it's false for the others.
+func compare(a, b types.AttributeValue) (int, bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		b, ok := b.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a.Value, b.Value), true
+	case *types.AttributeValueMemberN:
+		b, ok := b.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		x, errA := strconv.ParseFloat(a.Value, 64)
+		y, errB := strconv.ParseFloat(b.Value, 64)
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case *types.AttributeValueMemberB:
+		b, ok := b.(*types.AttributeValueMemberB)
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(a.Value, b.Value), true
+	}
+	return 0, false
+}
+
+func contains(value, operand types.AttributeValue) bool {
+	switch value := value.(type) {
+	case *types.AttributeValueMemberS:
+		s, ok := operand.(*types.AttributeValueMemberS)
+		return ok && strings.Contains(value.Value, s.Value)
+	case *types.AttributeValueMemberSS:
+		s, ok := operand.(*types.AttributeValueMemberS)
+		if !ok {
+			return false
+		}
+		for _, member := range value.Value {
+			if member == s.Value {
+				return true
+			}
+		}
+	case *types.AttributeValueMemberL:
+		for _, member := range value.Value {
+			if equal(member, operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
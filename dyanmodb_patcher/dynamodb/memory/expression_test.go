@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func str(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func TestConditionEval(t *testing.T) {
+	it := item{
+		"name":             str("Vendor One"),
+		"local_legal_name": str("Legal One"),
+		"rating":           &types.AttributeValueMemberN{Value: "4.5"},
+	}
+	names := map[string]string{"#name": "name", "#legal": "local_legal_name"}
+	values := map[string]types.AttributeValue{
+		":legal":  str("Legal One"),
+		":other":  str("Other"),
+		":prefix": str("Vendor"),
+		":low":    &types.AttributeValueMemberN{Value: "4"},
+		":high":   &types.AttributeValueMemberN{Value: "5"},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "attribute_exists(#legal)", want: true},
+		{expr: "attribute_not_exists(#legal)", want: false},
+		{expr: "attribute_not_exists(missing)", want: true},
+		{expr: "#legal = :legal", want: true},
+		{expr: "#legal <> :other", want: true},
+		{expr: "missing = :legal", want: false},
+		{expr: "missing <> :legal", want: true},
+		{expr: "begins_with(#name, :prefix)", want: true},
+		{expr: "begins_with(#legal, :prefix)", want: false},
+		{expr: "begins_with(missing, :prefix)", want: false},
+		{expr: "contains(#name, :other)", want: false},
+		{expr: "rating BETWEEN :low AND :high", want: true},
+		{expr: "rating > :high", want: false},
+		// AND binds tighter than OR: true OR (false AND false).
+		{expr: "#legal = :legal OR #legal = :other AND attribute_not_exists(#name)", want: true},
+		{expr: "(#legal = :legal OR #legal = :other) AND attribute_not_exists(#name)", want: false},
+		{expr: "NOT #legal = :other AND attribute_exists(#name)", want: true},
+		{expr: "attribute_not_exists(#legal) OR #legal = :legal", want: true},
+		{expr: "attribute_not_exists(#legal) OR #legal = :other", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cond, err := parseCondition(aws.String(tt.expr), names, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cond.eval(it); got != tt.want {
+				t.Errorf("eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConditionInvalid(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "#undefined = :legal", wantErr: "expression attribute name #undefined is not defined"},
+		{expr: "name = :undefined", wantErr: "expression attribute value :undefined is not defined"},
+		{expr: "begins_with(name)", wantErr: "begins_with should take a path and 2 arguments"},
+		{expr: "info.name = :legal", wantErr: "nested attribute paths are not supported"},
+		{expr: "name = :legal name", wantErr: `unexpected "name"`},
+		{expr: "(name = :legal", wantErr: `expected ")"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCondition(aws.String(tt.expr), nil, map[string]types.AttributeValue{":legal": str("Legal")})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateApply(t *testing.T) {
+	it := item{
+		"name":             str("Vendor One"),
+		"local_legal_name": str("Legal One"),
+		"legal_name":       str("Legal"),
+	}
+	names := map[string]string{"#legal": "local_legal_name"}
+	values := map[string]types.AttributeValue{":legal": str("Legal Two"), ":created": str("2024-01-01")}
+
+	tests := []struct {
+		expr      string
+		want      item
+		wantNames []string
+	}{
+		{
+			expr:      "SET #legal = :legal",
+			want:      item{"name": str("Vendor One"), "local_legal_name": str("Legal Two"), "legal_name": str("Legal")},
+			wantNames: []string{"local_legal_name"},
+		},
+		{
+			expr:      "REMOVE #legal, legal_name",
+			want:      item{"name": str("Vendor One")},
+			wantNames: []string{"local_legal_name", "legal_name"},
+		},
+		{
+			expr:      "SET #legal = :legal, created_at = if_not_exists(created_at, :created) REMOVE legal_name",
+			want:      item{"name": str("Vendor One"), "local_legal_name": str("Legal Two"), "created_at": str("2024-01-01")},
+			wantNames: []string{"local_legal_name", "created_at", "legal_name"},
+		},
+		{
+			expr:      "SET name = if_not_exists(name, :legal)",
+			want:      it,
+			wantNames: []string{"name"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			u, err := parseUpdate(aws.String(tt.expr), names, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updated, updatedNames := u.apply(it)
+			if !reflect.DeepEqual(updated, tt.want) {
+				t.Errorf("item = %v, want %v", updated, tt.want)
+			}
+			if !reflect.DeepEqual(updatedNames, tt.wantNames) {
+				t.Errorf("updated names = %v, want %v", updatedNames, tt.wantNames)
+			}
+		})
+	}
+
+	if value := it["local_legal_name"].(*types.AttributeValueMemberS).Value; value != "Legal One" {
+		t.Errorf("the stored item is mutated to %q", value)
+	}
+}
+
+func TestParseUpdateInvalid(t *testing.T) {
+	for _, expr := range []string{"", "ADD count :one", "SET count = count + :one", "SET name = if_not_exists(other, :one)"} {
+		if _, err := parseUpdate(aws.String(expr), nil, map[string]types.AttributeValue{":one": str("1")}); err == nil {
+			t.Errorf("expected an error for %q", expr)
+		}
+	}
+}
+
+func TestParseProjection(t *testing.T) {
+	paths, err := parseProjection(aws.String("PK, #sk, name"), map[string]string{"#sk": "SK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	if want := []string{"PK", "SK", "name"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+)
+
+// Load returns a DB seeded from a JSON fixture mapping table names to their items, e.g.
+//
+//	{
+//	  "asia-staging-table-ordering": [
+//	    {"PK": "GEID#FP_SG", "SK": "GEID#FP_SG,VENDOR#a1b2", "vendor_code": "a1b2", "name": "Dine-in Cafe"}
+//	  ]
+//	}
+//
+// Items are converted like attributevalue.MarshalMap does, e.g. JSON numbers become N and objects become M.
+func Load(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture map[string][]map[string]interface{}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+
+	db := New()
+	for tableName, items := range fixture {
+		db.CreateTable(tableName)
+		for i, value := range items {
+			it, err := attributevalue.MarshalMap(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid item %v of table %s in fixture %s: %w", i, tableName, path, err)
+			}
+			if err := db.Put(tableName, it); err != nil {
+				return nil, fmt.Errorf("invalid item %v of table %s in fixture %s: %w", i, tableName, path, err)
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// WriteFixture saves the tables of db in the format of Load, ordered by key. Sets are saved as lists.
+func (db *DB) WriteFixture(path string) error {
+	db.mu.RLock()
+	fixture := make(map[string][]map[string]interface{}, len(db.tables))
+	for tableName, t := range db.tables {
+		partitions := make([]string, 0, len(t.partitions))
+		for pk := range t.partitions {
+			partitions = append(partitions, pk)
+		}
+		sort.Strings(partitions)
+
+		items := []map[string]interface{}{}
+		for _, pk := range partitions {
+			for _, it := range t.partitions[pk] {
+				var value map[string]interface{}
+				if err := attributevalue.UnmarshalMap(it, &value); err != nil {
+					db.mu.RUnlock()
+					return fmt.Errorf("failed to convert item %s/%s of table %s: %w", pk, sortKeyOf(it), tableName, err)
+				}
+				items = append(items, value)
+			}
+		}
+		fixture[tableName] = items
+	}
+	db.mu.RUnlock()
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal fixture: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,592 @@
+// Package memory is an in-memory DynamoDB implementing dynamodb.DDBClient, it's used to rehearse runs offline
+// and to test the patcher. Tables have a string partition key PK and a string sort key SK like the tables of the
+// dine-in service.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Key attributes of every table.
+const (
+	PartitionKey = "PK"
+	SortKey      = "SK"
+)
+
+// DefaultPageSize is the number of items a Query reads per page when its Limit is not set, it stands for the 1 MB
+// page of DynamoDB so that paging is exercised with small tables.
+const DefaultPageSize = 100
+
+// ErrValidation is returned for requests DynamoDB would reject with a ValidationException, including the ones
+// using expressions this package doesn't support.
+var ErrValidation = errors.New("ValidationException")
+
+// DB is an in-memory DynamoDB, it's safe for concurrent use.
+type DB struct {
+	mu     sync.RWMutex
+	tables map[string]*table
+	// PageSize is the default Limit of Query.
+	PageSize int
+}
+
+// table holds the items of a table by partition key, sorted by sort key.
+type table struct {
+	partitions map[string][]item
+}
+
+func New() *DB {
+	return &DB{
+		tables:   make(map[string]*table),
+		PageSize: DefaultPageSize,
+	}
+}
+
+// CreateTable creates an empty table, it's a no-op when the table exists.
+func (db *DB) CreateTable(name string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.tables[name]; !ok {
+		db.tables[name] = &table{partitions: make(map[string][]item)}
+	}
+}
+
+// Put stores it in the table, replacing the item with the same key.
+func (db *DB) Put(tableName string, it map[string]types.AttributeValue) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(&tableName)
+	if err != nil {
+		return err
+	}
+
+	pk, sk, err := keyOf(it)
+	if err != nil {
+		return err
+	}
+
+	t.put(pk, sk, copyItem(it))
+	return nil
+}
+
+func (db *DB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	t, err := db.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCondition, err := parseCondition(params.KeyConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validation("invalid key condition: %v", err)
+	}
+	pk, err := partitionOf(keyCondition)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := parseCondition(params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validation("invalid filter: %v", err)
+	}
+
+	projection, err := parseProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, validation("invalid projection: %v", err)
+	}
+
+	items := t.partitions[pk]
+	if params.ScanIndexForward != nil && !*params.ScanIndexForward {
+		reversed := make([]item, len(items))
+		for i, it := range items {
+			reversed[len(items)-1-i] = it
+		}
+		items = reversed
+	}
+
+	start := 0
+	if params.ExclusiveStartKey != nil {
+		_, startSK, err := keyOf(params.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		for start < len(items) && !isAfter(items[start], startSK, params.ScanIndexForward) {
+			start++
+		}
+	}
+
+	limit := db.PageSize
+	if params.Limit != nil {
+		limit = int(*params.Limit)
+	}
+
+	output := &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{}}
+	evaluated := 0
+	for _, it := range items[start:] {
+		if !keyCondition.eval(it) {
+			continue
+		}
+
+		evaluated++
+		if filter == nil || filter.eval(it) {
+			output.Items = append(output.Items, project(it, projection))
+		}
+		// like DynamoDB, a page stopped by the limit has a LastEvaluatedKey even when no item follows it.
+		if limit > 0 && evaluated == limit {
+			output.LastEvaluatedKey = keyAttributes(it)
+			break
+		}
+	}
+
+	output.Count = int32(len(output.Items))
+	output.ScannedCount = int32(evaluated)
+	if params.Select == types.SelectCount {
+		output.Items = nil
+	}
+	return output, nil
+}
+
+// Scan reads the items of every partition, ordered by partition key and then sort key. Parallel scans with
+// Segment aren't supported.
+func (db *DB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	t, err := db.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.TotalSegments != nil {
+		return nil, validation("parallel scan is not supported")
+	}
+
+	filter, err := parseCondition(params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validation("invalid filter: %v", err)
+	}
+
+	projection, err := parseProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, validation("invalid projection: %v", err)
+	}
+
+	partitions := make([]string, 0, len(t.partitions))
+	for pk := range t.partitions {
+		partitions = append(partitions, pk)
+	}
+	sort.Strings(partitions)
+
+	var startPK, startSK string
+	if params.ExclusiveStartKey != nil {
+		if startPK, startSK, err = keyOf(params.ExclusiveStartKey); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := db.PageSize
+	if params.Limit != nil {
+		limit = int(*params.Limit)
+	}
+
+	output := &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{}}
+	evaluated := 0
+scan:
+	for _, pk := range partitions {
+		if pk < startPK {
+			continue
+		}
+
+		for _, it := range t.partitions[pk] {
+			if pk == startPK && sortKeyOf(it) <= startSK {
+				continue
+			}
+			evaluated++
+			if filter == nil || filter.eval(it) {
+				output.Items = append(output.Items, project(it, projection))
+			}
+			if limit > 0 && evaluated == limit {
+				output.LastEvaluatedKey = keyAttributes(it)
+				break scan
+			}
+		}
+	}
+
+	output.Count = int32(len(output.Items))
+	output.ScannedCount = int32(evaluated)
+	return output, nil
+}
+
+func (db *DB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	t, err := db.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, sk, err := keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	projection, err := parseProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, validation("invalid projection: %v", err)
+	}
+
+	output := &dynamodb.GetItemOutput{}
+	if it, ok := t.get(pk, sk); ok {
+		output.Item = project(it, projection)
+	}
+	return output, nil
+}
+
+func (db *DB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	apply, err := t.prepareUpdate(params.Key, params.UpdateExpression, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	old, updated, names := apply()
+	output := &dynamodb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllOld:
+		output.Attributes = old
+	case types.ReturnValueAllNew:
+		output.Attributes = updated
+	case types.ReturnValueUpdatedOld:
+		output.Attributes = project(old, names)
+	case types.ReturnValueUpdatedNew:
+		output.Attributes = project(updated, names)
+	}
+	return output, nil
+}
+
+func (db *DB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	output := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]types.AttributeValue)}
+	for tableName, keys := range params.RequestItems {
+		t, err := db.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+
+		projection, err := parseProjection(keys.ProjectionExpression, keys.ExpressionAttributeNames)
+		if err != nil {
+			return nil, validation("invalid projection: %v", err)
+		}
+
+		for _, key := range keys.Keys {
+			pk, sk, err := keyOf(key)
+			if err != nil {
+				return nil, err
+			}
+			if it, ok := t.get(pk, sk); ok {
+				output.Responses[tableName] = append(output.Responses[tableName], project(it, projection))
+			}
+		}
+	}
+	return output, nil
+}
+
+// TransactWriteItems supports ConditionCheck, Put, Update and Delete actions, all conditions are evaluated before
+// any item is written.
+func (db *DB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	applies := make([]func(), 0, len(params.TransactItems))
+	isCanceled := false
+	seen := map[string]bool{}
+
+	for i, transactItem := range params.TransactItems {
+		reasons[i].Code = aws.String("None")
+
+		var tableName *string
+		var key, newItem item
+		var condition *string
+		var names map[string]string
+		var values map[string]types.AttributeValue
+		switch {
+		case transactItem.ConditionCheck != nil:
+			action := transactItem.ConditionCheck
+			tableName, key, condition, names, values = action.TableName, action.Key, action.ConditionExpression, action.ExpressionAttributeNames, action.ExpressionAttributeValues
+		case transactItem.Put != nil:
+			action := transactItem.Put
+			tableName, newItem, condition, names, values = action.TableName, action.Item, action.ConditionExpression, action.ExpressionAttributeNames, action.ExpressionAttributeValues
+			key = newItem
+		case transactItem.Delete != nil:
+			action := transactItem.Delete
+			tableName, key, condition, names, values = action.TableName, action.Key, action.ConditionExpression, action.ExpressionAttributeNames, action.ExpressionAttributeValues
+		case transactItem.Update != nil:
+			action := transactItem.Update
+			tableName, key = action.TableName, action.Key
+		default:
+			return nil, validation("transact item %v has no action", i)
+		}
+
+		t, err := db.table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		pk, sk, err := keyOf(key)
+		if err != nil {
+			return nil, err
+		}
+
+		k := fmt.Sprintf("%s\x00%s\x00%s", *tableName, pk, sk)
+		if seen[k] {
+			return nil, validation("transaction request cannot include multiple operations on one item")
+		}
+		seen[k] = true
+
+		if action := transactItem.Update; action != nil {
+			apply, err := t.prepareUpdate(action.Key, action.UpdateExpression, action.ConditionExpression, action.ExpressionAttributeNames, action.ExpressionAttributeValues)
+			var conditionErr *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionErr) {
+				reasons[i].Code = aws.String("ConditionalCheckFailed")
+				reasons[i].Message = conditionErr.Message
+				isCanceled = true
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			applies = append(applies, func() { apply() })
+			continue
+		}
+
+		cond, err := parseCondition(condition, names, values)
+		if err != nil {
+			return nil, validation("invalid condition: %v", err)
+		}
+		current, _ := t.get(pk, sk)
+		if cond != nil && !cond.eval(current) {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			reasons[i].Message = aws.String("The conditional request failed")
+			isCanceled = true
+			continue
+		}
+
+		switch {
+		case newItem != nil:
+			stored := copyItem(newItem)
+			applies = append(applies, func() { t.put(pk, sk, stored) })
+		case transactItem.Delete != nil:
+			applies = append(applies, func() { t.delete(pk, sk) })
+		}
+	}
+
+	if isCanceled {
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+
+	for _, apply := range applies {
+		apply()
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// prepareUpdate checks the condition of an update on the item of key and returns a function applying it, which
+// returns the item before and after the update, and the names of the updated attributes. db.mu must be held.
+func (t *table) prepareUpdate(key item, updateExpr, conditionExpr *string, names map[string]string, values map[string]types.AttributeValue) (func() (item, item, []string), error) {
+	pk, sk, err := keyOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	upd, err := parseUpdate(updateExpr, names, values)
+	if err != nil {
+		return nil, validation("invalid update: %v", err)
+	}
+	for _, action := range upd.set {
+		if action.path == PartitionKey || action.path == SortKey {
+			return nil, validation("cannot update attribute %s, it's part of the key", action.path)
+		}
+	}
+	for _, path := range upd.remove {
+		if path == PartitionKey || path == SortKey {
+			return nil, validation("cannot update attribute %s, it's part of the key", path)
+		}
+	}
+
+	cond, err := parseCondition(conditionExpr, names, values)
+	if err != nil {
+		return nil, validation("invalid condition: %v", err)
+	}
+
+	old, exists := t.get(pk, sk)
+	if cond != nil && !cond.eval(old) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	return func() (item, item, []string) {
+		base := old
+		if !exists {
+			base = keyAttributes(key)
+		}
+
+		updated, updatedNames := upd.apply(base)
+		t.put(pk, sk, updated)
+		return old, updated, updatedNames
+	}, nil
+}
+
+func (db *DB) table(name *string) (*table, error) {
+	if name == nil {
+		return nil, validation("table name is required")
+	}
+
+	t, ok := db.tables[*name]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Requested resource not found: Table: %s not found", *name))}
+	}
+	return t, nil
+}
+
+// index returns the position of sk in the partition and whether it exists.
+func (t *table) index(pk, sk string) (int, bool) {
+	items := t.partitions[pk]
+	i := sort.Search(len(items), func(i int) bool {
+		return sortKeyOf(items[i]) >= sk
+	})
+	return i, i < len(items) && sortKeyOf(items[i]) == sk
+}
+
+func (t *table) get(pk, sk string) (item, bool) {
+	i, ok := t.index(pk, sk)
+	if !ok {
+		return nil, false
+	}
+	return t.partitions[pk][i], true
+}
+
+func (t *table) put(pk, sk string, it item) {
+	i, ok := t.index(pk, sk)
+	if ok {
+		t.partitions[pk][i] = it
+		return
+	}
+
+	items := append(t.partitions[pk], nil)
+	copy(items[i+1:], items[i:])
+	items[i] = it
+	t.partitions[pk] = items
+}
+
+func (t *table) delete(pk, sk string) {
+	i, ok := t.index(pk, sk)
+	if !ok {
+		return
+	}
+
+	items := t.partitions[pk]
+	t.partitions[pk] = append(items[:i], items[i+1:]...)
+}
+
+// partitionOf returns the partition a key condition is on, it must compare the partition key with =.
+func partitionOf(cond condition) (string, error) {
+	switch c := cond.(type) {
+	case comparison:
+		if c.op == "=" && c.left.path == PartitionKey {
+			if value, ok := c.right.value.(*types.AttributeValueMemberS); ok {
+				return value.Value, nil
+			}
+		}
+	case andCondition:
+		if pk, err := partitionOf(c.left); err == nil {
+			return pk, nil
+		}
+		return partitionOf(c.right)
+	}
+	return "", validation("key condition should compare %s with = to a string", PartitionKey)
+}
+
+// isAfter tells whether it comes after the sort key sk in the order of the query.
+func isAfter(it item, sk string, scanIndexForward *bool) bool {
+	if scanIndexForward != nil && !*scanIndexForward {
+		return sortKeyOf(it) < sk
+	}
+	return sortKeyOf(it) > sk
+}
+
+func keyOf(it item) (string, string, error) {
+	pk, okPK := it[PartitionKey].(*types.AttributeValueMemberS)
+	sk, okSK := it[SortKey].(*types.AttributeValueMemberS)
+	if !okPK || !okSK {
+		return "", "", validation("the provided key element does not match the schema, %s and %s should be strings", PartitionKey, SortKey)
+	}
+	return pk.Value, sk.Value, nil
+}
+
+func sortKeyOf(it item) string {
+	sk, _ := it[SortKey].(*types.AttributeValueMemberS)
+	if sk == nil {
+		return ""
+	}
+	return sk.Value
+}
+
+func keyAttributes(it item) item {
+	return item{
+		PartitionKey: it[PartitionKey],
+		SortKey:      it[SortKey],
+	}
+}
+
+// project returns the attributes of it in names, or a copy of it when names is empty.
+func project(it item, names []string) item {
+	if it == nil {
+		return nil
+	}
+	if len(names) == 0 {
+		return copyItem(it)
+	}
+
+	projected := make(item, len(names))
+	for _, name := range names {
+		if value, ok := it[name]; ok {
+			projected[name] = value
+		}
+	}
+	return projected
+}
+
+func copyItem(it item) item {
+	copied := make(item, len(it))
+	for name, value := range it {
+		copied[name] = value
+	}
+	return copied
+}
+
+func validation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrValidation, fmt.Sprintf(format, args...))
+}
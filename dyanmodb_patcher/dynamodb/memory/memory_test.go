@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func newVendorsDB(t *testing.T, n int) *DB {
+	t.Helper()
+
+	db := New()
+	db.CreateTable("vendors")
+	for i := 0; i < n; i++ {
+		it := item{
+			PartitionKey: str("GEID#FP_SG"),
+			SortKey:      str(fmt.Sprintf("VENDOR#v%03d", i)),
+		}
+		if i%2 == 0 {
+			it["local_legal_name"] = str("Legal")
+		}
+		if err := db.Put("vendors", it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestQueryPaging(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int32
+		filter    *string
+		wantPages [][]string
+	}{
+		{
+			name:      "limit splits the pages",
+			limit:     2,
+			wantPages: [][]string{{"v000", "v001"}, {"v002", "v003"}, {"v004"}},
+		},
+		{
+			// the page ending on the last item still has a LastEvaluatedKey, so an empty page follows.
+			name:      "limit reached on the last item",
+			limit:     5,
+			wantPages: [][]string{{"v000", "v001", "v002", "v003", "v004"}, {}},
+		},
+		{
+			name:      "limit counts the filtered out items",
+			limit:     2,
+			filter:    aws.String("attribute_not_exists(local_legal_name)"),
+			wantPages: [][]string{{"v001"}, {"v003"}, {}},
+		},
+		{
+			name:      "limit above the items",
+			limit:     10,
+			wantPages: [][]string{{"v000", "v001", "v002", "v003", "v004"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newVendorsDB(t, 5)
+			in := &dynamodb.QueryInput{
+				TableName:                 aws.String("vendors"),
+				KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+				FilterExpression:          tt.filter,
+				ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("GEID#FP_SG"), ":prefix": str("VENDOR#")},
+				Limit:                     aws.Int32(tt.limit),
+			}
+
+			pages := [][]string{}
+			for {
+				out, err := db.Query(context.Background(), in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				page := []string{}
+				for _, it := range out.Items {
+					page = append(page, it[SortKey].(*types.AttributeValueMemberS).Value[len("VENDOR#"):])
+				}
+				pages = append(pages, page)
+
+				if out.LastEvaluatedKey == nil {
+					break
+				}
+				if len(pages) > len(tt.wantPages) {
+					t.Fatalf("pages = %v, want %v", pages, tt.wantPages)
+				}
+				in.ExclusiveStartKey = out.LastEvaluatedKey
+			}
+
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestScanPaging(t *testing.T) {
+	db := newVendorsDB(t, 4)
+	in := &dynamodb.ScanInput{TableName: aws.String("vendors"), Limit: aws.Int32(2)}
+
+	var counts []int32
+	for {
+		out, err := db.Scan(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, out.Count)
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+
+	if want := []int32{2, 2, 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts of the pages = %v, want %v", counts, want)
+	}
+}
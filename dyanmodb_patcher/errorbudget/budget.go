@@ -0,0 +1,105 @@
+// Package errorbudget stops a patch once too many vendors fail, e.g. when vendor service returns 500 for everyone.
+package errorbudget
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrExceeded is wrapped by the error Record returns once the budget is exceeded.
+var ErrExceeded = errors.New("error budget is exceeded")
+
+// DefaultWindow is the number of the latest results the error rate is measured on.
+const DefaultWindow = 100
+
+type Config struct {
+	// MaxErrors is the number of failures tolerated, 0 means unlimited.
+	MaxErrors int
+	// MaxErrorRate is the percentage of failures tolerated in the latest Window results, 0 means unlimited.
+	MaxErrorRate float64
+	// Window is the number of the latest results MaxErrorRate is measured on. The rate is not checked before Window
+	// results are recorded so that the first failures don't abort the patch.
+	Window int
+}
+
+// IsExceeded tells whether err is returned by an exceeded budget.
+func IsExceeded(err error) bool {
+	return errors.Is(err, ErrExceeded)
+}
+
+func (c Config) IsEnabled() bool {
+	return c.MaxErrors > 0 || c.MaxErrorRate > 0
+}
+
+// Budget counts the failures of a patch, it's safe for concurrent use. A nil Budget is never exceeded.
+type Budget struct {
+	name string
+	cfg  Config
+
+	mu     sync.Mutex
+	errors int
+	// window is a ring of the latest results, true for a failure.
+	window       []bool
+	next         int
+	recorded     int
+	windowErrors int
+	exceeded     error
+}
+
+// New returns the budget of name, e.g. a GEID, it returns nil when cfg is not enabled.
+func New(name string, cfg Config) *Budget {
+	if !cfg.IsEnabled() {
+		return nil
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+
+	return &Budget{
+		name:   name,
+		cfg:    cfg,
+		window: make([]bool, cfg.Window),
+	}
+}
+
+// Record counts the result of a vendor. It returns an error wrapping ErrExceeded once the budget is exceeded, and
+// keeps returning it afterwards.
+func (b *Budget) Record(isFailed bool) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exceeded != nil {
+		return b.exceeded
+	}
+
+	if b.window[b.next] {
+		b.windowErrors--
+	}
+	b.window[b.next] = isFailed
+	b.next = (b.next + 1) % len(b.window)
+	b.recorded++
+	if isFailed {
+		b.errors++
+		b.windowErrors++
+	}
+
+	if b.cfg.MaxErrors > 0 && b.errors > b.cfg.MaxErrors {
+		b.exceeded = fmt.Errorf("%w in %s: %v vendors failed over the max of %v", ErrExceeded, b.name, b.errors, b.cfg.MaxErrors)
+		return b.exceeded
+	}
+
+	if b.cfg.MaxErrorRate > 0 && b.recorded >= len(b.window) {
+		rate := float64(b.windowErrors) / float64(len(b.window)) * 100
+		if rate > b.cfg.MaxErrorRate {
+			b.exceeded = fmt.Errorf("%w in %s: %.1f%% of the latest %v vendors failed over the max of %.1f%%", ErrExceeded, b.name, rate, len(b.window), b.cfg.MaxErrorRate)
+			return b.exceeded
+		}
+	}
+
+	return nil
+}
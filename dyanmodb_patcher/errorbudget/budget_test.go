@@ -0,0 +1,52 @@
+package errorbudget
+
+import "testing"
+
+func TestMaxErrors(t *testing.T) {
+	b := New("FP_SG", Config{MaxErrors: 2})
+	for i, isFailed := range []bool{true, false, true} {
+		if err := b.Record(isFailed); err != nil {
+			t.Fatalf("result %v exceeded the budget: %v", i, err)
+		}
+	}
+
+	if err := b.Record(true); !IsExceeded(err) {
+		t.Fatalf("err = %v, want the budget exceeded on the 3rd failure", err)
+	}
+	if err := b.Record(false); !IsExceeded(err) {
+		t.Errorf("err = %v, want the budget to stay exceeded", err)
+	}
+}
+
+func TestMaxErrorRate(t *testing.T) {
+	b := New("FP_SG", Config{MaxErrorRate: 50, Window: 4})
+
+	// the rate isn't checked before the window is full.
+	for i := 0; i < 3; i++ {
+		if err := b.Record(true); err != nil {
+			t.Fatalf("result %v exceeded the budget before the window is full: %v", i, err)
+		}
+	}
+
+	// 3 of 4 failed, then the failures slide out of the window.
+	if err := b.Record(false); !IsExceeded(err) {
+		t.Fatalf("err = %v, want the budget exceeded at 75%%", err)
+	}
+
+	b = New("FP_SG", Config{MaxErrorRate: 50, Window: 4})
+	for i, isFailed := range []bool{true, true, false, false, false, true, true, false} {
+		if err := b.Record(isFailed); err != nil {
+			t.Fatalf("result %v exceeded the budget at 50%%: %v", i, err)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	b := New("FP_SG", Config{Window: 10})
+	if b != nil {
+		t.Fatal("expected a nil budget without limits")
+	}
+	if err := b.Record(true); err != nil {
+		t.Errorf("nil budget returned %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/audit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+const historyCommand = "history"
+
+// runHistory lists the audited changes of a vendor, from the audit file or from the audit items of the table.
+func runHistory(args []string) {
+	var vendorFlag, geidFlag string
+
+	fs := flag.NewFlagSet(historyCommand, flag.ExitOnError)
+	fs.StringVar(&vendorFlag, "vendor", "", "[Required] The code of the vendor.")
+	fs.StringVar(&geidFlag, "geid", "", "The GEID of the vendor. It's required with audit flag table, the records of every GEID are listed from the file otherwise.")
+	fs.StringVar(&auditFlag, "audit", auditSinkFile, "Where the changes are audited, file or table.")
+	fs.StringVar(&auditFileFlag, "audit-file", "", "The JSONL file of audit flag file, it defaults to "+audit.FileName+" under the output directory.")
+	fs.StringVar(&outputDirFlag, "output-dir", "output", "The directory where run artifacts are written to.")
+	fs.StringVar(&envFlag, "env", "staging", "The environment of the table of audit flag table.")
+	fs.StringVar(&configFlag, "config", "", "A YAML file of environments, see the patch flags.")
+	fs.StringVar(&backendFlag, "backend", backendAWS, "The DynamoDB backend, aws or memory.")
+	fs.StringVar(&fixtureFlag, "fixture", "", "The tables of memory backend, e.g. the "+memoryTablesFile+" saved by a rehearsed run.")
+	fs.StringVar(&logFormatFlag, "log-format", logFormatText, "The format of log lines, text or json.")
+	fs.StringVar(&logLevelFlag, "log-level", "info", "The minimum level of log lines, debug, info, warn or error.")
+	fs.Parse(args)
+	setupLogging(os.Stderr)
+
+	if vendorFlag == "" {
+		fatal(slog.Default(), "vendor flag is required")
+	}
+
+	records, err := vendorHistory(context.Background(), geidFlag, vendorFlag)
+	if err != nil {
+		fatal(slog.Default(), "Failed to read the history of vendor", "vendor_code", vendorFlag, "error", err)
+	}
+
+	if err := writeHistory(os.Stdout, records); err != nil {
+		fatal(slog.Default(), "Failed to print the history of vendor", "vendor_code", vendorFlag, "error", err)
+	}
+}
+
+// vendorHistory returns the audit records of vendorCode from the sink of audit flag, oldest first.
+func vendorHistory(ctx context.Context, geid, vendorCode string) ([]tovendor.AuditRecord, error) {
+	switch auditFlag {
+	case auditSinkFile:
+		return audit.History(auditFilePath(), geid, vendorCode, slog.Default())
+	case auditSinkTable:
+		if geid == "" {
+			return nil, fmt.Errorf("geid flag is required with audit flag %s", auditSinkTable)
+		}
+		if err := openBackend(); err != nil {
+			return nil, err
+		}
+
+		cfg, err := loadConfig(configFlag, envFlag)
+		if err != nil {
+			return nil, err
+		}
+		ddbClient, err := newDDBClient(cfg.AWS)
+		if err != nil {
+			return nil, err
+		}
+		return tovendor.NewAuditTable(ddbClient, cfg.AWS.DynamoDBTableName, nil).History(ctx, geid, vendorCode)
+	}
+
+	return nil, fmt.Errorf("audit flag should be %s or %s", auditSinkFile, auditSinkTable)
+}
+
+func writeHistory(w io.Writer, records []tovendor.AuditRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOPERATOR\tRUN_ID\tENV\tGEID\tTARGET\tATTRIBUTE\tOLD\tNEW")
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.Time.Format(time.RFC3339), record.Operator, record.RunID, record.Env, record.GEID, record.Target,
+			record.Attribute, describeValue(record.OldValue), describeValue(record.NewValue))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,131 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+const fileName = "journal.jsonl"
+
+// Entry is the record of a single write, Previous is nil when the attribute didn't exist before the write.
+type Entry struct {
+	RunID string `json:"run_id"`
+	Env   string `json:"env"`
+	// Table, Region and Endpoint are where the write went, so that undo doesn't depend on the config of env at the
+	// time it runs. They are empty in the entries of older runs.
+	Table      string    `json:"table"`
+	Region     string    `json:"region"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	GEID       string    `json:"geid"`
+	VendorCode string    `json:"vendor_code"`
+	Attribute  string    `json:"attribute"`
+	Previous   *string   `json:"previous"`
+	Written    string    `json:"written"`
+	Time       time.Time `json:"time"`
+}
+
+// Writer appends entries to the journal of a run, it's safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	runID string
+	cfg   config.Config
+	file  *os.File
+}
+
+// Open opens the journal of runID under dir, its entries are the writes to the table of cfg.
+func Open(dir, runID string, cfg config.Config) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, fileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return &Writer{
+		runID: runID,
+		cfg:   cfg,
+		file:  file,
+	}, nil
+}
+
+func (w *Writer) Append(geid string, change tovendor.Change, previous *string) error {
+	line, err := json.Marshal(Entry{
+		RunID:      w.runID,
+		Env:        w.cfg.Env,
+		Table:      w.cfg.AWS.DynamoDBTableName,
+		Region:     w.cfg.AWS.Region,
+		Endpoint:   w.cfg.AWS.DynamoDBEndpoint,
+		GEID:       geid,
+		VendorCode: change.VendorCode,
+		Attribute:  change.Attribute,
+		Previous:   previous,
+		Written:    change.Proposed,
+		Time:       time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// the entry is synced before we move on, it's the only way to revert the write.
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	return w.file.Sync()
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// Read returns the entries of the journal under dir in the order they were written. A cut off last line is skipped
+// with a warning, a malformed line before it fails the read.
+func Read(dir string, logger *slog.Logger) ([]Entry, error) {
+	file, err := os.Open(filepath.Join(dir, fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	var malformed error
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if malformed != nil {
+			return nil, malformed
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			malformed = fmt.Errorf("malformed journal entry at line %d: %w", lineNo, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if malformed != nil {
+		logger.Warn("Skip the last journal entry, it was cut off", "error", malformed)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package journal
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// writeJournal appends an entry per vendor code to the journal under dir, followed by tail.
+func writeJournal(t *testing.T, dir string, tail string, vendorCodes ...string) {
+	t.Helper()
+
+	w, err := Open(dir, "run", config.Config{Env: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range vendorCodes {
+		if err := w.Append("FP_SG", tovendor.Change{VendorCode: code, Attribute: "local_legal_name", Proposed: "Legal"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, fileName), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(tail); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSkipsCutOffLastEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeJournal(t, dir, `{"run_id":"run","vendor_co`, "v001", "v002")
+
+	var logs bytes.Buffer
+	entries, err := Read(dir, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].VendorCode != "v001" || entries[1].VendorCode != "v002" {
+		t.Errorf("entries = %+v, want v001 and v002", entries)
+	}
+	if !strings.Contains(logs.String(), "line 3") {
+		t.Errorf("cut off entry is not warned: %s", logs.String())
+	}
+}
+
+func TestReadFailsOnMalformedEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeJournal(t, dir, "not json\n", "v001")
+	writeJournal(t, dir, "", "v002")
+
+	if _, err := Read(dir, slog.Default()); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected the malformed line 2 to fail the read, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger returns a logger writing records of level or above to w in format, json or text.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %s, it should be debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %s, it should be %s or %s", format, logFormatText, logFormatJSON)
+}
+
+// setupLogging makes the logger of log-format and log-level flags writing to w the default one, the lines of the log
+// package are written by it as well.
+func setupLogging(w io.Writer) {
+	logger, err := newLogger(w, logFormatFlag, logLevelFlag)
+	if err != nil {
+		fatal(slog.Default(), "Invalid logging flags", "error", err)
+	}
+	slog.SetDefault(logger)
+}
+
+// runLogger returns the logger of a run, its lines carry the run id, the env and the target.
+func runLogger(runID, env, target string) *slog.Logger {
+	return slog.Default().With("run_id", runID, "env", env, "target", target)
+}
+
+// logVendor logs the result of patching vendor, at debug level unless it failed so that a run of many vendors
+// isn't flooded with lines at the default level.
+func logVendor(logger *slog.Logger, vendor tovendor.Vendor, result patcher.Result, duration time.Duration) {
+	level := slog.LevelDebug
+	if result.Status.IsFailed() {
+		level = slog.LevelWarn
+	}
+
+	args := []interface{}{
+		"vendor_code", vendor.Code,
+		"outcome", string(result.Status),
+		"duration", duration,
+	}
+	if result.Current != "" || result.Proposed != "" {
+		args = append(args, "current", result.Current, "proposed", result.Proposed)
+	}
+	if result.Err != nil {
+		args = append(args, "error", result.Err)
+	}
+	logger.Log(context.Background(), level, "Patched vendor", args...)
+}
+
+// fatal logs msg at error level and exits with 1.
+func fatal(logger *slog.Logger, msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
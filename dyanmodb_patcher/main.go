@@ -2,24 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/audit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/checkpoint"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
 	vendorSrv "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
-	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/errorbudget"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/journal"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/metrics"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/progress"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/report"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/selection"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
 )
 
 type Patcher interface {
-	Patch(ctx context.Context, vendors tovendor.Vendor)
+	Patch(ctx context.Context, vendors tovendor.Vendor) patcher.Result
 	ValidateEnvConfig() error
 }
 
@@ -28,64 +44,181 @@ const (
 	localLegalName = "local_legal_name"
 )
 
-// declaration block for application constants.
-var (
-	// allGEIDs is the entity id list that we deployed the dine-in service.
-	allGEIDs = []string{
-		"FP_BD",
-		"FP_HK",
-		"FP_MY",
-		"FP_PH",
-		"FP_PK",
-		"FP_SG",
-		"FP_TH",
-		"FP_TW",
-	}
+// barInterval is how often progress bars are redrawn.
+const barInterval = 500 * time.Millisecond
+
+// metricsFile is the default textfile of metrics under the run directory.
+const metricsFile = "metrics.prom"
+
+const (
+	errorBudgetScopeGEID = "geid"
+	errorBudgetScopeRun  = "run"
 )
 
 // declaration block for flags.
 var (
 	envFlag               string
+	configFlag            string
 	geidsFlag             utils.GlobalEntitiesFlag
 	targetFlag            string
 	maxConcurrentTaskFlag uint
 	isForAllEntitiesFlag  bool
+	discoverGEIDsFlag     bool
+	isDryRunFlag          bool
+	outputDirFlag         string
+	resumeRunIDFlag       string
+	vendorRPSFlag         float64
+	ddbWPSFlag            float64
+	ddbRPSFlag            float64
+	gracePeriodFlag       time.Duration
+	specFlag              string
+	sourceFileFlag        string
+	overwriteFlag         string
+	vendorsFlag           selection.VendorCodesFlag
+	vendorFileFlag        string
+	sampleFlag            int
+	sampleSeedFlag        int64
+	limitFlag             int
+	shardFlag             selection.ShardFlag
+	canaryFlag            int
+	canaryMaxErrorFlag    float64
+	batchWritesFlag       bool
+	backendFlag           string
+	fixtureFlag           string
+	maxErrorsFlag         int
+	maxErrorRateFlag      float64
+	errorWindowFlag       int
+	errorBudgetScopeFlag  string
+	metricsAddrFlag       string
+	metricsFileFlag       string
+	pushgatewayFlag       string
+	logFormatFlag         string
+	logLevelFlag          string
+	progressIntervalFlag  time.Duration
+	progressEstimateFlag  bool
+	auditFlag             string
+	auditFileFlag         string
 )
 
 func init() {
-	flag.StringVar(&envFlag, "env", "staging", "staging or prod")
+	flag.StringVar(&envFlag, "env", "staging", "The environment of config, e.g. staging or prod.")
+	flag.StringVar(&configFlag, "config", "", "A YAML file of environments, they are added to the built-in staging and prod or replace them. See config/environments.yaml for the format.")
 	flag.Var(&geidsFlag, "geid", "[Required] Comma separated list of Pandora Global Entity IDs. For example, \"FP_SG,FP_TW\". It's required when all flag is not set")
-	flag.StringVar(&targetFlag, "target", "", "[Required] The target for this patch task. For example, local_legal_name.")
-	flag.UintVar(&maxConcurrentTaskFlag, "n", 1, "The maximum concurrent Patch we would execute. Use sequential processing as default.")
-	flag.BoolVar(&isForAllEntitiesFlag, "all", false, "Set true if you want to run the patch task for all entites in a env. It would ignore geid flag when it's set.")
+	flag.StringVar(&targetFlag, "target", "", "[Required] The target for this patch task. For example, local_legal_name. It defaults to the name of spec when spec flag is set.")
+	flag.UintVar(&maxConcurrentTaskFlag, "n", 1, "The maximum concurrent Patch we would execute, shared fairly by the GEIDs patched in parallel. Use sequential processing as default.")
+	flag.BoolVar(&isForAllEntitiesFlag, "all", false, "Set true if you want to run the patch task for all entites in a env, they are the geids of its config. It would ignore geid flag when it's set.")
+	flag.BoolVar(&discoverGEIDsFlag, "discover-geids", false, "With all flag, patch the GEIDs found in the partition keys of the table instead of the geids of config, differences between them are warned before the run starts. It scans the whole table.")
+	flag.BoolVar(&isDryRunFlag, "dry-run", false, "Set true to compute the new values without writing them. The per-vendor diff is printed and saved under the output directory.")
+	flag.StringVar(&outputDirFlag, "output-dir", "output", "The directory where run artifacts, e.g. dry-run diffs and checkpoints, are written to.")
+	flag.StringVar(&resumeRunIDFlag, "resume", "", "The run id to resume. Vendors completed in that run are skipped, the run must have the same target, env and spec.")
+	flag.Float64Var(&vendorRPSFlag, "vendor-rps", 0, "The maximum requests per second to vendor service, shared by all GEIDs. 0 means unlimited.")
+	flag.Float64Var(&ddbWPSFlag, "ddb-wps", 0, "The maximum DynamoDB writes per second, shared by all GEIDs. 0 means unlimited.")
+	flag.Float64Var(&ddbRPSFlag, "ddb-rps", 0, "The maximum DynamoDB scan pages per second of discover-geids flag. 0 means unlimited.")
+	flag.DurationVar(&gracePeriodFlag, "grace-period", 30*time.Second, "How long in-flight vendors can take to finish after SIGINT or SIGTERM. A second signal exits right away.")
+	flag.StringVar(&specFlag, "spec", "", "A YAML or JSON file declaring a backfill target, see specs/local_legal_name.yaml for an example.")
+	flag.StringVar(&sourceFileFlag, "source-file", "", "A .csv or .jsonl file with vendor_code, value and an optional geid column. When it's set, only the listed vendors are patched with the values of the file instead of vendor service.")
+	flag.StringVar(&overwriteFlag, "overwrite", "", "When local_legal_name target writes a vendor whose local_legal_name is set, never or if_different to correct the values differing from the source, e.g. the ones of source-file flag. It defaults to never, a spec declares its own.")
+	flag.Var(&vendorsFlag, "vendors", "Comma separated list of vendor codes to patch. For example, \"a1b2,c3d4\".")
+	flag.StringVar(&vendorFileFlag, "vendor-file", "", "A file with a vendor code per line to patch, it's merged with vendors flag.")
+	flag.IntVar(&sampleFlag, "sample", 0, "Patch a random sample of N vendors, it's reproducible with the same sample-seed.")
+	flag.Int64Var(&sampleSeedFlag, "sample-seed", 1, "The seed of sample flag.")
+	flag.IntVar(&limitFlag, "limit", 0, "Patch at most N vendors, ordered by vendor code.")
+	flag.Var(&shardFlag, "shard", "Patch the i-th of n shards in the form of i/n, e.g. 0/4. Vendors are sharded by a stable hash of vendor code.")
+	flag.IntVar(&canaryFlag, "canary", 0, "Patch the first N selected vendors of each GEID and verify them before the rest.")
+	flag.Float64Var(&canaryMaxErrorFlag, "canary-max-error-rate", 0, "The maximum error rate in percentage of the canary to proceed with the rest automatically. Above it, the rest proceeds only after an interactive confirmation.")
+	flag.BoolVar(&batchWritesFlag, "batch-writes", false, "Write the vendors by up to 25 items with TransactWriteItems, use it with n flag of 25 or more. It keeps the conditions of single writes, so a vendor changed after it was read is never overwritten.")
+	flag.StringVar(&backendFlag, "backend", backendAWS, "The DynamoDB backend, aws or memory. memory rehearses the run offline on the tables of fixture flag and saves them under the run directory.")
+	flag.StringVar(&fixtureFlag, "fixture", "", "A JSON file mapping table names to their items, it seeds the tables of memory backend. See fixtures/example.json for an example.")
+	flag.IntVar(&maxErrorsFlag, "max-errors", 0, "Abort once more than N vendors failed. 0 means unlimited.")
+	flag.Float64Var(&maxErrorRateFlag, "max-error-rate", 0, "Abort once the failures of the latest error-window vendors are over the percentage. 0 means unlimited.")
+	flag.IntVar(&errorWindowFlag, "error-window", errorbudget.DefaultWindow, "The number of the latest vendors max-error-rate flag is measured on.")
+	flag.StringVar(&errorBudgetScopeFlag, "error-budget-scope", errorBudgetScopeGEID, "What an exceeded error budget aborts, geid counts the failures of every GEID apart and aborts the GEID, run counts them together and aborts the whole run. The vendors in flight are cancelled either way.")
+	flag.StringVar(&metricsAddrFlag, "metrics-addr", "", "The address to expose Prometheus metrics on /metrics during the run, e.g. :9090.")
+	flag.StringVar(&metricsFileFlag, "metrics-file", "", "The Prometheus textfile the metrics are saved to at exit, e.g. for the textfile collector of the node exporter. It defaults to "+metricsFile+" under the run directory.")
+	flag.StringVar(&pushgatewayFlag, "pushgateway", "", "The URL of a pushgateway the metrics are pushed to at exit, grouped by run id.")
+	flag.StringVar(&logFormatFlag, "log-format", logFormatText, "The format of log lines, text or json.")
+	flag.StringVar(&logLevelFlag, "log-level", "info", "The minimum level of log lines, debug, info, warn or error. Every vendor is logged at debug.")
+	flag.DurationVar(&progressIntervalFlag, "progress-interval", 10*time.Second, "How often the processed vendors, rate and ETA of every GEID are logged, 0 disables it. When stderr is a terminal and log-format is text, progress bars are drawn instead. The total is the number of vendors read so far, see progress-estimate flag.")
+	flag.BoolVar(&progressEstimateFlag, "progress-estimate", false, "Estimate the total of the progress from a count of the vendors of every GEID before they are read, it reads their partition once more.")
+	flag.StringVar(&auditFlag, "audit", auditSinkFile, "Where the operator of EMAIL, the run, the old and the new value of every write are recorded: file appends them to audit-file, table writes them as items of the table next to the vendors, off doesn't record them.")
+	flag.StringVar(&auditFileFlag, "audit-file", "", "The JSONL file of audit flag file, it defaults to "+audit.FileName+" under the output directory.")
+	flag.Usage = usage
+}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nSubcommands:\n")
+	fmt.Fprintf(out, "  %s -run <run-id>\n\tRevert the writes of a run.\n", undoCommand)
+	fmt.Fprintf(out, "  %s -vendor <vendor-code> -geid <geid>\n\tList the audited changes of a vendor.\n", historyCommand)
+	fmt.Fprintf(out, "  %s [flags]\n\tCompare the target attribute with its source without writing, it accepts the flags above.\n", verifyCommand)
 }
 
 func main() {
-	flag.Parse()
+	// it's loaded here rather than in init so that the tests of the package don't depend on it.
+	if err := godotenv.Load(); err != nil {
+		fatal(slog.Default(), "Error loading .env file", "error", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == undoCommand {
+		runUndo(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == historyCommand {
+		runHistory(os.Args[2:])
+		return
+	}
+
+	args := os.Args[1:]
+	isVerify := len(args) > 0 && args[0] == verifyCommand
+	if isVerify {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	// log lines are written through the reporter so that they don't break its bars.
+	reporter := newProgressReporter()
+	setupLogging(reporter.Writer(os.Stderr))
+
+	var spec *patcher.Spec
+	if specFlag != "" {
+		var err error
+		spec, err = patcher.LoadSpec(specFlag)
+		if err != nil {
+			fatal(slog.Default(), "Failed to load spec", "error", err)
+		}
+	}
 
-	err := validateRequiredFlags()
+	err := validateRequiredFlags(spec)
 	if err != nil {
-		log.Fatal(err)
+		fatal(slog.Default(), "Invalid flags", "error", err)
+	}
+	if sourceFileFlag != "" && targetFlag == localLegalName && overwriteFlag != string(patcher.OverwriteIfDifferent) {
+		slog.Warn("Vendors whose local_legal_name is set are skipped, set overwrite flag to if_different to correct them with the source file", "source_file", sourceFileFlag)
 	}
 
-	env, err := utils.EnvFromString(envFlag)
+	filter, err := vendorFilter()
 	if err != nil {
-		log.Fatal(err)
+		fatal(slog.Default(), "Invalid vendor selection", "error", err)
 	}
 
-	cfg, err := config.GetByEnv(env)
+	if err := openBackend(); err != nil {
+		fatal(slog.Default(), "Failed to open backend", "error", err)
+	}
+
+	cfg, err := loadConfig(configFlag, envFlag)
 	if err != nil {
-		log.Fatalf("failed to get config: %v", err)
+		fatal(slog.Default(), "Failed to get config", "env", envFlag, "error", err)
 	}
 
 	var geids []string
-	if isForAllEntitiesFlag {
-		geids = allGEIDs
+	if isForAllEntitiesFlag && discoverGEIDsFlag {
+		geids, err = discoverGEIDs(context.Background(), cfg)
+		if err != nil {
+			fatal(slog.Default(), "Failed to discover GEIDs", "env", cfg.Env, "error", err)
+		}
+	} else if isForAllEntitiesFlag {
+		geids = cfg.GEIDs
 	} else {
 		geids = geidsFlag
 	}
@@ -94,73 +227,637 @@ func main() {
 	for _, geid := range geids {
 		globalEntity, err := utils.NewGlobalEntity(geid)
 		if err != nil {
-			log.Fatal(err)
+			fatal(slog.Default(), "Invalid GEID", "geid", geid, "error", err)
 		}
 		globalEntities = append(globalEntities, globalEntity)
 	}
 
-	for _, globalEntity := range globalEntities {
-		patch(globalEntity, cfg, targetFlag, maxConcurrentTaskFlag)
+	runID := newRunID()
+	isResuming := resumeRunIDFlag != "" && !isVerify
+	if isResuming {
+		runID = resumeRunIDFlag
+	}
+	logger := runLogger(runID, cfg.Env, targetFlag)
+	if isVerify {
+		logger.Info("Start verify")
+	} else if isResuming {
+		logger.Info("Resume run")
+	} else {
+		logger.Info("Start run", "geids", geids)
 	}
+
+	vendorLimiter := ratelimit.New("vendor_service", vendorRPSFlag)
+	retryCfg := retryhttp.DefaultConfig
+	retryCfg.Limiter = vendorLimiter
+
+	r := &run{
+		id:                 runID,
+		cfg:                cfg,
+		target:             targetFlag,
+		spec:               spec,
+		filter:             filter,
+		canarySize:         canaryFlag,
+		canaryMaxErrorRate: canaryMaxErrorFlag,
+		budget:             newBudget(maxConcurrentTaskFlag),
+		httpClient:         retryhttp.NewClient(&http.Client{Timeout: cfg.VendorService.Timeout}, retryCfg),
+		vendorLimiter:      vendorLimiter,
+		ddbWriteLimiter:    ratelimit.New("dynamodb_write", ddbWPSFlag),
+		errorBudget: errorbudget.Config{
+			MaxErrors:    maxErrorsFlag,
+			MaxErrorRate: maxErrorRateFlag,
+			Window:       errorWindowFlag,
+		},
+		isRunErrorBudget: errorBudgetScopeFlag == errorBudgetScopeRun,
+		metrics:          metrics.New(),
+		logger:           logger,
+		progress:         reporter,
+	}
+
+	if metricsAddrFlag != "" {
+		server, err := r.metrics.Serve(metricsAddrFlag, logger)
+		if err != nil {
+			fatal(logger, "Failed to serve metrics", "error", err)
+		}
+		defer server.Close()
+		logger.Info("Serve metrics", "url", metricsAddrFlag+"/metrics")
+	}
+
+	if isVerify {
+		runVerify(r, globalEntities)
+		return
+	}
+
+	if !isDryRunFlag {
+		r.checkpoint, err = checkpoint.Open(runDir(runID), runID, r.checkpointHeader(), isResuming, logger)
+		if err != nil {
+			fatal(logger, "Failed to open checkpoint", "error", err)
+		}
+		defer r.checkpoint.Close()
+
+		r.journal, err = journal.Open(runDir(runID), runID, cfg)
+		if err != nil {
+			fatal(logger, "Failed to open journal", "error", err)
+		}
+		defer r.journal.Close()
+
+		r.auditFile, err = openAudit()
+		if err != nil {
+			fatal(logger, "Failed to open audit", "error", err)
+		}
+		if r.auditFile != nil {
+			defer r.auditFile.Close()
+		}
+	}
+
+	scheduleCtx, workCtx, stop := withShutdown(context.Background(), gracePeriodFlag)
+	defer stop()
+
+	start := time.Now()
+	r.progress.Start()
+	errs := patchAll(scheduleCtx, workCtx, r, globalEntities)
+	r.progress.Stop()
+	if !isDryRunFlag {
+		saveBackend(runID, memoryTablesFile)
+	}
+	exportMetrics(r)
+
+	var incomplete []string
+	for i, globalEntity := range globalEntities {
+		if errs[i] != nil {
+			incomplete = append(incomplete, globalEntity.ID)
+		}
+	}
+	if len(incomplete) > 0 {
+		fatal(logger, "Run is not completed, resume it with -resume "+runID, "outcome", "incomplete", "geids", incomplete, "duration", time.Since(start))
+	}
+	logger.Info("Run is completed", "outcome", "completed", "geids", len(globalEntities), "duration", time.Since(start))
 }
 
-func patch(globalEntity utils.GlobalEntity, cfg config.Config, target string, maxConcurrentTask uint) {
-	patchers := map[string]Patcher{}
-	initializePatchers(patchers, globalEntity, cfg)
+// run holds the state shared by the patch of every GEID in a run.
+type run struct {
+	id     string
+	cfg    config.Config
+	target string
+	spec   *patcher.Spec
+	filter selection.Filter
+	// canarySize vendors are patched and verified before the rest of each GEID when it's positive.
+	canarySize         int
+	canaryMaxErrorRate float64
+	// budget is the concurrency of n flag shared by the GEIDs.
+	budget     *budget
+	httpClient *retryhttp.Client
+	// errorBudget is the config of the error budget of every GEID, or of the whole run when isRunErrorBudget is set.
+	errorBudget      errorbudget.Config
+	isRunErrorBudget bool
+	// runErrors is the error budget shared by the GEIDs when isRunErrorBudget is set, it's set by patchAll.
+	runErrors *errorGuard
+	// abortRun stops scheduling the vendors of every GEID on a fatal error, it's set by patchAll.
+	abortRun context.CancelCauseFunc
+	// metrics is nil when they are not recorded.
+	metrics *metrics.Metrics
+	// logger carries the run id, the env and the target.
+	logger *slog.Logger
+	// progress is nil when it's not reported.
+	progress *progress.Reporter
+	// auditFile is nil unless the writes are audited to a file.
+	auditFile *audit.File
+	// checkpoint and journal are nil in dry-run mode.
+	checkpoint      *checkpoint.Store
+	journal         *journal.Writer
+	vendorLimiter   *ratelimit.Limiter
+	ddbWriteLimiter *ratelimit.Limiter
+}
 
-	patcher, err := getPatcherByTarget(patchers, target)
+// recorder returns the recorder of the metrics of globalEntity, it's nil when metrics are not recorded.
+func (r *run) recorder(globalEntity utils.GlobalEntity) *metrics.Recorder {
+	return r.metrics.For(metrics.Labels{GEID: globalEntity.ID, Env: r.cfg.Env, Target: r.target})
+}
+
+// checkpointHeader returns what the run patches, a resumed run must patch the same.
+func (r *run) checkpointHeader() checkpoint.Header {
+	header := checkpoint.Header{Target: r.target, Env: r.cfg.Env}
+	if r.spec != nil {
+		header.Spec = r.spec.Digest()
+	}
+	return header
+}
+
+// geidLogger returns the logger of the patch of globalEntity.
+func (r *run) geidLogger(globalEntity utils.GlobalEntity) *slog.Logger {
+	return r.logger.With("geid", globalEntity.ID)
+}
+
+func (r *run) rateLimitStats() []ratelimit.Stats {
+	var stats []ratelimit.Stats
+	for _, limiter := range []*ratelimit.Limiter{r.vendorLimiter, r.ddbWriteLimiter} {
+		if limiter != nil {
+			stats = append(stats, limiter.Stats())
+		}
+	}
+	return stats
+}
+
+// patch runs the patcher of the run target on the vendors of globalEntity not completed in the checkpoint. Vendors
+// are scheduled until scheduleCtx is done and patched with workCtx. It returns why the patch is interrupted or aborted.
+func patch(scheduleCtx, workCtx context.Context, r *run, globalEntity utils.GlobalEntity) error {
+	scheduleCtx, workCtx, abort := withAbort(scheduleCtx, workCtx)
+	defer abort(nil)
+	logger := r.geidLogger(globalEntity)
+
+	errGuard := r.runErrors
+	if errGuard == nil {
+		errGuard = &errorGuard{budget: errorbudget.New(globalEntity.ID, r.errorBudget), abort: abort}
+	}
+
+	repoOpts := []tovendor.Option{tovendor.WithWriteLimiter(r.ddbWriteLimiter)}
+	if batchCfg, ok := batchConfig(); ok {
+		repoOpts = append(repoOpts, tovendor.WithBatchWrites(batchCfg))
+	}
+	var diffReport *report.DiffReport
+	if isDryRunFlag {
+		diffReport = report.NewDiffReport()
+		repoOpts = append(repoOpts, tovendor.WithDryRun(diffReport))
+	} else {
+		repoOpts = append(repoOpts, tovendor.WithJournal(r.journal))
+	}
+
+	wl, err := loadWorkload(r, globalEntity, repoOpts...)
 	if err != nil {
-		log.Fatalf("Failed to get patcher by target: %v", err)
+		return err
+	}
+
+	tracker := r.progress.Track(globalEntity.ID, logger)
+	defer tracker.Finish()
+
+	summary := report.NewSummary(r.id, r.cfg.Env, globalEntity.ID, r.target, isDryRunFlag)
+	stream := streamVendors(scheduleCtx, r, globalEntity, wl, tracker)
+
+	var canary *report.Canary
+	batch := patchVendors(scheduleCtx, workCtx, r, globalEntity, wl.patcher, stream, r.canarySize, errGuard, summary, tracker)
+
+	if r.canarySize > 0 && batch.fatalErr == nil && scheduleCtx.Err() == nil && stream.hasNext(scheduleCtx) {
+		logger.Info("Patched canary vendors, verify them before the rest", "vendors", len(batch.results))
+		canary = verifyCanary(workCtx, logger, wl.vendorRepository, batch.results, r.canaryMaxErrorRate)
+		canary.Proceeded = canary.IsHealthy() || confirmRollout(globalEntity.ID, canary)
+		summary.SetCanary(canary)
+
+		if canary.Proceeded {
+			rest := patchVendors(scheduleCtx, workCtx, r, globalEntity, wl.patcher, stream, 0, errGuard, summary, tracker)
+			batch.skipped += rest.skipped
+			batch.fatalErr = rest.fatalErr
+		}
+	}
+
+	if err := stream.stop(); err != nil && batch.fatalErr == nil {
+		batch.fatalErr = fmt.Errorf("failed to get vendor list: %w", err)
+	}
+	summary.SetUnknownSourceCodes(stream.unknownSourceCodes)
+
+	var runAborted *runAbortedError
+	if cause := context.Cause(scheduleCtx); batch.fatalErr == nil && (errorbudget.IsExceeded(cause) || errors.As(cause, &runAborted)) {
+		// the run is aborted by the error budget it shares with other GEIDs, or by the fatal error of another GEID.
+		batch.fatalErr = cause
+	}
+	if batch.fatalErr != nil {
+		summary.SetAborted(batch.fatalErr.Error())
 	}
 
-	vendors, err := getAllVendors(globalEntity, cfg)
+	if batch.skipped > 0 {
+		logger.Info("Skipped vendors completed before the run was resumed", "vendors", batch.skipped)
+	}
+	summary.SetResumed(batch.skipped)
+	if err := summary.MergePrevious(runDir(r.id)); err != nil {
+		logger.Error("Failed to merge the summary of the earlier attempt", "error", err)
+	}
+	summary.Finish(r.rateLimitStats(), scheduleCtx.Err() != nil)
+	logger.Info("Completed patching", "summary", summary.String())
+	if err := summary.Write(runDir(r.id)); err != nil {
+		logger.Error("Failed to write summary report", "error", err)
+	}
+
+	if diffReport != nil {
+		if err := writeDiffReport(logger, diffReport, r.id, globalEntity); err != nil {
+			return err
+		}
+	}
+
+	if errorbudget.IsExceeded(batch.fatalErr) {
+		return batch.fatalErr
+	}
+	if batch.fatalErr != nil {
+		return fmt.Errorf("aborted on a fatal error: %w", batch.fatalErr)
+	}
+
+	if canary != nil && !canary.Proceeded && canary.Size == 0 {
+		return fmt.Errorf("aborted as the canary verified no vendor")
+	}
+	if canary != nil && !canary.Proceeded {
+		return fmt.Errorf("aborted as the canary had error rate %.1f%% over %.1f%%", canary.ErrorRate, canary.MaxErrorRate)
+	}
+
+	return scheduleCtx.Err()
+}
+
+// workload is the patcher of the run target in a GEID, its vendors are read with streamVendors.
+type workload struct {
+	vendorRepository *tovendor.DDBRepository
+	patcher          Patcher
+	// source is the source file which lists the vendors to work on, it's nil when source-file flag is not set.
+	source *source.File
+}
+
+// loadWorkload initializes the patcher of the run target in globalEntity.
+func loadWorkload(r *run, globalEntity utils.GlobalEntity, repoOpts ...tovendor.Option) (*workload, error) {
+	if r.spec != nil {
+		repoOpts = append(repoOpts, tovendor.WithAttributes(r.spec.Attributes()...))
+	}
+
+	ddbClient, err := newDDBClient(r.cfg.AWS)
 	if err != nil {
-		log.Fatalf("Failed to get vendor list: %v", err)
+		return nil, err
 	}
 
+	httpClient := r.httpClient
+	if recorder := r.recorder(globalEntity); recorder != nil {
+		ddbClient = ddbClient.WithObserver(recorder)
+		httpClient = httpClient.WithObserver(recorder)
+	}
+
+	repoOpts = withAudit(repoOpts, r.auditFile, ddbClient, r.cfg.AWS.DynamoDBTableName, r.ddbWriteLimiter, tovendor.AuditMetadata{
+		Operator: operator(),
+		RunID:    r.id,
+		Env:      r.cfg.Env,
+		Target:   r.target,
+	})
+
+	repoOpts = append(repoOpts, tovendor.WithLogger(r.geidLogger(globalEntity)))
+	wl := &workload{
+		vendorRepository: tovendor.NewDDBRepository(globalEntity, r.cfg, ddbClient, repoOpts...),
+	}
+
+	var src source.Source
+	if sourceFileFlag != "" {
+		wl.source, err = source.LoadFile(sourceFileFlag, globalEntity.ID)
+		if err != nil {
+			return nil, err
+		}
+		src = wl.source
+	}
+
+	patchers := map[string]Patcher{}
+	initializePatchers(patchers, globalEntity, r.cfg, wl.vendorRepository, httpClient, r.spec, src)
+
+	wl.patcher, err = getPatcherByTarget(patchers, r.target)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get patcher by target: %v", err)
+	}
+
+	return wl, nil
+}
+
+// batchOutcome is the outcome of patchVendors.
+type batchOutcome struct {
+	// skipped is the number of vendors completed before the run was resumed.
+	skipped  int
+	results  []patcher.Result
+	fatalErr error
+}
+
+// patchVendors patches up to limit vendors of stream, all of them when limit is 0, and adds the results to summary
+// and tracker. It stops on a fatal error, the error budget or scheduleCtx, and waits for the vendors in flight.
+func patchVendors(scheduleCtx, workCtx context.Context, r *run, globalEntity utils.GlobalEntity, p Patcher, stream *vendorStream, limit int, errGuard *errorGuard, summary *report.Summary, tracker *progress.Tracker) batchOutcome {
+	var outcome batchOutcome
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	guard := make(chan struct{}, maxConcurrentTask)
+	var isAborted atomic.Bool
+	recorder := r.recorder(globalEntity)
+	logger := r.geidLogger(globalEntity)
+
+	// taken counts the vendors dispatched, the ones completed before the run was resumed are not counted.
+	for taken := 0; limit <= 0 || taken < limit; {
+		if isAborted.Load() || scheduleCtx.Err() != nil {
+			break
+		}
+
+		queued, ok := stream.next(scheduleCtx)
+		if !ok {
+			break
+		}
 
-	for _, vendor := range vendors {
+		vendor := queued.vendor
+		if r.checkpoint != nil && r.checkpoint.IsCompleted(globalEntity.ID, vendor.Code) {
+			outcome.skipped++
+			tracker.Observe(progress.Resumed)
+			stream.pages.done(queued.page, true)
+			continue
+		}
+
+		// the vendor is taken from the stream but not patched when the patch stops while it waits for a slot, so
+		// it's left out of the checkpoint and its page is never recorded as completed.
+		if err := r.budget.acquire(scheduleCtx, globalEntity.ID); err != nil {
+			stream.pages.done(queued.page, false)
+			break
+		}
+		if isAborted.Load() {
+			r.budget.release(globalEntity.ID)
+			stream.pages.done(queued.page, false)
+			break
+		}
+		taken++
 		wg.Add(1)
-		guard <- struct{}{}
 
-		go func(vendor tovendor.Vendor) {
-			patcher.Patch(context.TODO(), vendor)
+		go func(vendor tovendor.Vendor, page int) {
+			start := time.Now()
+			result := p.Patch(workCtx, vendor)
+			logVendor(logger, vendor, result, time.Since(start))
+			summary.Add(result)
+			recorder.ObserveVendor(string(result.Status))
+			tracker.Observe(progressOutcome(result.Status))
+
+			mu.Lock()
+			outcome.results = append(outcome.results, result)
+			// a write that is not journaled can't be undone, so we stop before writing more of them.
+			if (retryhttp.IsFatal(result.Err) || result.Status == patcher.StatusNotJournaled) && outcome.fatalErr == nil {
+				outcome.fatalErr = result.Err
+				isAborted.Store(true)
+				r.abortRun(&runAbortedError{geid: globalEntity.ID, err: result.Err})
+			}
+			if err := errGuard.record(result); err != nil && outcome.fatalErr == nil {
+				outcome.fatalErr = err
+				isAborted.Store(true)
+			}
+			mu.Unlock()
+
+			if r.checkpoint != nil {
+				if err := r.checkpoint.Record(globalEntity.ID, result); err != nil {
+					logger.Error("Failed to checkpoint vendor", "vendor_code", vendor.Code, "error", err)
+				}
+			}
+			stream.pages.done(page, result.Status.IsCompleted())
 			wg.Done()
-			<-guard
-		}(vendor)
+			r.budget.release(globalEntity.ID)
+		}(vendor, queued.page)
 	}
 	wg.Wait()
 
-	log.Printf("Completed patching for %v vendors in %s", len(vendors), globalEntity.ID)
+	return outcome
+}
+
+func vendorFilter() (selection.Filter, error) {
+	codes := []string(vendorsFlag)
+	if vendorFileFlag != "" {
+		fileCodes, err := selection.ReadVendorCodes(vendorFileFlag)
+		if err != nil {
+			return selection.Filter{}, err
+		}
+		codes = append(codes, fileCodes...)
+	}
+
+	if sampleFlag < 0 || limitFlag < 0 {
+		return selection.Filter{}, fmt.Errorf("sample and limit flags should not be negative")
+	}
+
+	return selection.Filter{
+		Codes:  codes,
+		Shard:  selection.Shard(shardFlag),
+		Sample: sampleFlag,
+		Seed:   sampleSeedFlag,
+		Limit:  limitFlag,
+	}, nil
+}
+
+func writeDiffReport(logger *slog.Logger, diffReport *report.DiffReport, runID string, globalEntity utils.GlobalEntity) error {
+	// the tables of GEIDs patched in parallel are printed one at a time.
+	stdoutMu.Lock()
+	err := diffReport.WriteTable(os.Stdout)
+	stdoutMu.Unlock()
+	if err != nil {
+		logger.Error("Failed to print dry-run diff", "error", err)
+	}
+
+	path := filepath.Join(runDir(runID), fmt.Sprintf("dry-run-%s.json", globalEntity.ID))
+	if err := diffReport.WriteJSONFile(path); err != nil {
+		return fmt.Errorf("failed to write dry-run diff: %w", err)
+	}
+
+	logger.Info("Dry run proposed changes", "changes", len(diffReport.Changes()), "path", path)
+	return nil
+}
+
+// newProgressReporter returns the reporter of progress-interval flag, it draws bars when stderr is a terminal and
+// the log lines are text. It's nil when the progress is disabled.
+func newProgressReporter() *progress.Reporter {
+	if progressIntervalFlag <= 0 {
+		return nil
+	}
+
+	stat, err := os.Stderr.Stat()
+	isTerminal := err == nil && stat.Mode()&os.ModeCharDevice != 0 && strings.ToLower(logFormatFlag) == logFormatText
+	interval := progressIntervalFlag
+	if isTerminal {
+		interval = barInterval
+	}
+	return progress.New(os.Stderr, isTerminal, interval, &stdoutMu)
+}
+
+// progressOutcome returns the outcome of status reported in the progress.
+func progressOutcome(status patcher.Status) progress.Outcome {
+	switch {
+	case status == patcher.StatusUpdated:
+		return progress.Updated
+	case status.IsFailed():
+		return progress.Failed
+	}
+	return progress.Skipped
+}
+
+// batchConfig returns the config of batched writes, it's false when writes are not batched.
+func batchConfig() (tovendor.BatchConfig, bool) {
+	return tovendor.DefaultBatchConfig, batchWritesFlag
+}
+
+// exportMetrics saves the metrics of the run to the textfile and pushes them to the pushgateway if any.
+func exportMetrics(r *run) {
+	path := metricsFileFlag
+	if path == "" {
+		path = filepath.Join(runDir(r.id), metricsFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		r.logger.Error("Failed to save metrics", "error", err)
+	} else if err := r.metrics.WriteTextfile(path); err != nil {
+		r.logger.Error("Failed to save metrics", "error", err)
+	} else {
+		r.logger.Info("Metrics are saved", "path", path)
+	}
+
+	if pushgatewayFlag != "" {
+		if err := r.metrics.Push(pushgatewayFlag, r.id); err != nil {
+			r.logger.Error("Failed to push metrics", "url", pushgatewayFlag, "error", err)
+		}
+	}
+}
+
+// loadConfig returns the config of env from the built-in environments and the ones of the file at path.
+func loadConfig(path, env string) (config.Config, error) {
+	envs, err := config.Load(path)
+	if err != nil {
+		return config.Config{}, err
+	}
+	return envs.Get(env)
 }
 
-func validateRequiredFlags() error {
+// discoverGEIDs returns the GEIDs found in the table of cfg and warns about the ones that differ from its geids.
+// GEIDs unknown to pd-go-kit are skipped.
+func discoverGEIDs(ctx context.Context, cfg config.Config) ([]string, error) {
+	ddbClient, err := newDDBClient(cfg.AWS)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := slog.Default().With("env", cfg.Env, "table", cfg.AWS.DynamoDBTableName)
+	logger.Info("Discover GEIDs from table")
+	discovered, err := tovendor.DiscoverGEIDs(ctx, ddbClient, cfg.AWS.DynamoDBTableName, ratelimit.New("dynamodb_read", ddbRPSFlag))
+	if err != nil {
+		return nil, err
+	}
+
+	configured := make(map[string]bool, len(cfg.GEIDs))
+	for _, geid := range cfg.GEIDs {
+		configured[geid] = true
+	}
+
+	var geids []string
+	for _, geid := range discovered {
+		if _, err := utils.NewGlobalEntity(geid); err != nil {
+			logger.Warn("Skip GEID found in table", "geid", geid, "error", err)
+			continue
+		}
+		if !configured[geid] {
+			logger.Warn("GEID is found in table but it's not in the geids of env", "geid", geid)
+		}
+		delete(configured, geid)
+		geids = append(geids, geid)
+	}
+	for _, geid := range cfg.GEIDs {
+		if configured[geid] {
+			logger.Warn("GEID of env has no vendor in table", "geid", geid)
+		}
+	}
+
+	if len(geids) == 0 {
+		return nil, fmt.Errorf("no GEID is found in table %s", cfg.AWS.DynamoDBTableName)
+	}
+	logger.Info("Discovered GEIDs", "geids", geids)
+	return geids, nil
+}
+
+// newRunID returns an identifier of the run which is used to group its artifacts.
+func newRunID() string {
+	return time.Now().UTC().Format("20060102T150405Z")
+}
+
+func runDir(runID string) string {
+	return filepath.Join(outputDirFlag, runID)
+}
+
+func validateRequiredFlags(spec *patcher.Spec) error {
 	if !isForAllEntitiesFlag && geidsFlag == nil {
 		return fmt.Errorf("geid flag is required when 'all' flag is not set")
 	}
+	if discoverGEIDsFlag && !isForAllEntitiesFlag {
+		return fmt.Errorf("discover-geids flag requires 'all' flag")
+	}
+
+	if spec != nil {
+		if targetFlag == "" {
+			targetFlag = spec.Name
+		}
+		if targetFlag != spec.Name {
+			return fmt.Errorf("target flag %s doesn't match the name of spec %s", targetFlag, spec.Name)
+		}
+	}
 
 	if targetFlag == "" {
 		return fmt.Errorf("target flag is required")
 	}
 
-	return nil
-}
+	if overwriteFlag != "" {
+		if spec != nil {
+			return fmt.Errorf("overwrite flag doesn't apply to spec, declare overwrite in the spec instead")
+		}
+		if policy := patcher.OverwritePolicy(overwriteFlag); policy != patcher.OverwriteNever && policy != patcher.OverwriteIfDifferent {
+			return fmt.Errorf("overwrite flag should be %s or %s", patcher.OverwriteNever, patcher.OverwriteIfDifferent)
+		}
+	}
 
-func initializePatchers(patchers map[string]Patcher, globalEntity utils.GlobalEntity, cfg config.Config) {
-	ddbClient, err := dynamodb.NewClient(cfg.AWS)
-	if err != nil {
-		log.Fatal(err)
+	if maxErrorsFlag < 0 || maxErrorRateFlag < 0 || errorWindowFlag < 0 {
+		return fmt.Errorf("max-errors, max-error-rate and error-window flags should not be negative")
+	}
+	if errorBudgetScopeFlag != errorBudgetScopeGEID && errorBudgetScopeFlag != errorBudgetScopeRun {
+		return fmt.Errorf("error-budget-scope flag should be %s or %s", errorBudgetScopeGEID, errorBudgetScopeRun)
 	}
 
-	vendorRepository := tovendor.NewDDBRepository(globalEntity, cfg, ddbClient)
+	if err := validateAuditFlag(); err != nil {
+		return err
+	}
 
-	httpClient := &http.Client{}
+	return nil
+}
+
+// initializePatchers registers the patchers of every target and of spec if any, they all write through
+// vendorRepository. src replaces the vendor service source when it's not nil.
+func initializePatchers(patchers map[string]Patcher, globalEntity utils.GlobalEntity, cfg config.Config, vendorRepository *tovendor.DDBRepository, httpClient *retryhttp.Client, spec *patcher.Spec, src source.Source) {
 	vendorSrvClient := vendorSrv.NewClient(globalEntity, cfg, httpClient)
 
 	// register available patchers
-	patchers[localLegalName] = patcher.NewLocalLegalNamePatcher(vendorRepository, vendorSrvClient)
+	patchers[localLegalName] = patcher.NewLocalLegalNamePatcher(vendorRepository, vendorSrvClient, src, patcher.OverwritePolicy(overwriteFlag))
+
+	if spec != nil {
+		if _, ok := patchers[spec.Name]; ok {
+			fatal(slog.Default(), "Spec conflicts with a built-in target", "spec", spec.Name)
+		}
+		patchers[spec.Name] = patcher.NewSpecPatcher(spec, vendorRepository, vendorSrvClient, src)
+	}
 }
 
 func getPatcherByTarget(patchers map[string]Patcher, target string) (Patcher, error) {
@@ -175,17 +872,3 @@ func getPatcherByTarget(patchers map[string]Patcher, target string) (Patcher, er
 
 	return patcher, nil
 }
-
-func getAllVendors(globalEntity utils.GlobalEntity, cfg config.Config) ([]tovendor.Vendor, error) {
-	ddbClient, err := dynamodb.NewClient(cfg.AWS)
-	if err != nil {
-		return nil, err
-	}
-
-	vendorRepository := tovendor.NewDDBRepository(globalEntity, cfg, ddbClient)
-	vendors, err := vendorRepository.GetAllVendors(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-	return vendors, nil
-}
@@ -0,0 +1,1284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/audit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/checkpoint"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
+	vendorSrv "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service/vendorsrvtest"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb/memory"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/errorbudget"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/journal"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/metrics"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/progress"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/report"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+const (
+	testToken = "test-token"
+	testGEID  = "FP_SG"
+)
+
+// harness runs the patch flow of main against the in-memory DynamoDB and a fake vendor service.
+type harness struct {
+	t            *testing.T
+	vendorSrv    *vendorsrvtest.Server
+	globalEntity utils.GlobalEntity
+	r            *run
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	t.Setenv("VENDOR_SERVICE_TOKEN", testToken)
+	t.Setenv("EMAIL", "patcher@example.com")
+
+	server := vendorsrvtest.NewServer(testToken)
+	t.Cleanup(server.Close)
+
+	// undo reads the config of the env from the journal, so the test uses the table of staging.
+	envs, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := envs.Get("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.VendorService.EndpointFormatStr = server.EndpointFormatStr()
+
+	memoryDB = memory.New()
+	memoryDB.CreateTable(cfg.AWS.DynamoDBTableName)
+	t.Cleanup(func() { memoryDB = nil })
+
+	outputDirFlag = t.TempDir()
+	targetFlag = localLegalName
+	isDryRunFlag = false
+	sourceFileFlag = ""
+	overwriteFlag = ""
+	batchWritesFlag = false
+	metricsFileFlag = ""
+	auditFlag = auditSinkFile
+	auditFileFlag = ""
+	backendFlag = backendAWS
+	fixtureFlag = ""
+
+	globalEntity, err := utils.NewGlobalEntity(testGEID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retryCfg := retryhttp.Config{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+	}
+
+	return &harness{
+		t:            t,
+		vendorSrv:    server,
+		globalEntity: globalEntity,
+		r: &run{
+			id:         newRunID(),
+			cfg:        cfg,
+			target:     localLegalName,
+			budget:     newBudget(4),
+			httpClient: retryhttp.NewClient(&http.Client{Timeout: 200 * time.Millisecond}, retryCfg),
+			logger:     slog.Default(),
+		},
+	}
+}
+
+// addVendor puts a vendor of testGEID in the table and serves its account_name_localized when it's not nil.
+func (h *harness) addVendor(code, localLegalName string, accountNameLocalized *string) {
+	h.t.Helper()
+	h.addVendorIn(testGEID, code, localLegalName, accountNameLocalized)
+}
+
+func (h *harness) addVendorIn(geid, code, localLegalName string, accountNameLocalized *string) {
+	h.t.Helper()
+
+	item := map[string]types.AttributeValue{
+		"PK":          &types.AttributeValueMemberS{Value: "GEID#" + geid},
+		"SK":          &types.AttributeValueMemberS{Value: "GEID#" + geid + ",VENDOR#" + code},
+		"vendor_code": &types.AttributeValueMemberS{Value: code},
+		"name":        &types.AttributeValueMemberS{Value: "Vendor " + code},
+	}
+	if localLegalName != "" {
+		item["local_legal_name"] = &types.AttributeValueMemberS{Value: localLegalName}
+	}
+	if err := memoryDB.Put(h.r.cfg.AWS.DynamoDBTableName, item); err != nil {
+		h.t.Fatal(err)
+	}
+
+	body := map[string]interface{}{"code": code}
+	if accountNameLocalized != nil {
+		body[patcher.LocalLegalNameSourceField] = *accountNameLocalized
+	}
+	h.vendorSrv.SetVendor(geid, code, body)
+}
+
+// patch runs patch like main does, with a checkpoint and a journal unless it's a dry run.
+func (h *harness) patch(isResuming bool) {
+	h.t.Helper()
+
+	if err := h.patchAll(isResuming, h.globalEntity)[0]; err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// patchAll runs the GEIDs in parallel like main does and returns their errors.
+func (h *harness) patchAll(isResuming bool, globalEntities ...utils.GlobalEntity) []error {
+	h.t.Helper()
+
+	return h.patchWith(context.Background(), context.Background(), isResuming, globalEntities...)
+}
+
+// patchWith is patchAll with the contexts of scheduling and work, e.g. the ones of withShutdown.
+func (h *harness) patchWith(scheduleCtx, workCtx context.Context, isResuming bool, globalEntities ...utils.GlobalEntity) []error {
+	h.t.Helper()
+
+	if !isDryRunFlag {
+		var err error
+		h.r.checkpoint, err = checkpoint.Open(runDir(h.r.id), h.r.id, h.r.checkpointHeader(), isResuming, h.r.logger)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		defer h.r.checkpoint.Close()
+
+		h.r.journal, err = journal.Open(runDir(h.r.id), h.r.id, h.r.cfg)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		defer h.r.journal.Close()
+
+		h.r.auditFile, err = openAudit()
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		if h.r.auditFile != nil {
+			defer h.r.auditFile.Close()
+		}
+	}
+
+	return patchAll(scheduleCtx, workCtx, h.r, globalEntities)
+}
+
+// statuses returns the status of every vendor in the summary report.
+func (h *harness) statuses() map[string]patcher.Status {
+	h.t.Helper()
+
+	var summary struct {
+		Results []report.VendorResult `json:"results"`
+	}
+	readJSON(h.t, filepath.Join(runDir(h.r.id), "summary-"+testGEID+".json"), &summary)
+
+	statuses := map[string]patcher.Status{}
+	for _, result := range summary.Results {
+		statuses[result.VendorCode] = result.Status
+	}
+	return statuses
+}
+
+// localLegalName returns the local_legal_name of the vendor in the table, it's false when it's absent.
+func (h *harness) localLegalName(code string) (string, bool) {
+	h.t.Helper()
+
+	output, err := memoryDB.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.r.cfg.AWS.DynamoDBTableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "GEID#" + testGEID},
+			"SK": &types.AttributeValueMemberS{Value: "GEID#" + testGEID + ",VENDOR#" + code},
+		},
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	value, ok := output.Item["local_legal_name"].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", false
+	}
+	return value.Value, true
+}
+
+func readJSON(t *testing.T, path string, out interface{}) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPatchLocalLegalName(t *testing.T) {
+	h := newHarness(t)
+
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "Existing", aws.String("Legal Two"))
+	h.addVendor("v003", "", nil)
+	h.addVendor("v004", "", aws.String("Legal Four"))
+	h.vendorSrv.SetFault(testGEID, "v004", vendorsrvtest.Fault{Status: http.StatusNotFound})
+	h.addVendor("v005", "", aws.String("Legal Five"))
+	h.vendorSrv.SetFault(testGEID, "v005", vendorsrvtest.Fault{Status: http.StatusInternalServerError})
+	h.addVendor("v006", "", aws.String("Legal Six"))
+	h.vendorSrv.SetFault(testGEID, "v006", vendorsrvtest.Fault{Status: http.StatusInternalServerError, Times: 2})
+	h.addVendor("v007", "", aws.String("Legal Seven"))
+	h.vendorSrv.SetFault(testGEID, "v007", vendorsrvtest.Fault{Delay: time.Second})
+	h.addVendor("v008", "", aws.String("Legal Eight"))
+	h.vendorSrv.SetFault(testGEID, "v008", vendorsrvtest.Fault{MalformedJSON: true})
+	h.addVendor("v009", "", aws.String("Legal Nine"))
+	h.vendorSrv.SetFault(testGEID, "v009", vendorsrvtest.Fault{Status: http.StatusServiceUnavailable, RetryAfter: "86400", Times: 1})
+
+	h.patch(false)
+
+	tests := []struct {
+		code     string
+		status   patcher.Status
+		value    string
+		requests int
+	}{
+		{code: "v001", status: patcher.StatusUpdated, value: "Legal One", requests: 1},
+		{code: "v002", status: patcher.StatusSkippedAlreadySet, value: "Existing", requests: 0},
+		{code: "v003", status: patcher.StatusSkippedNoSourceValue, requests: 1},
+		{code: "v004", status: patcher.StatusSkippedNotFound, requests: 1},
+		{code: "v005", status: patcher.StatusFailedSource, requests: 3},
+		{code: "v006", status: patcher.StatusUpdated, value: "Legal Six", requests: 3},
+		{code: "v007", status: patcher.StatusFailedSource, requests: 3},
+		{code: "v008", status: patcher.StatusFailedSource, requests: 1},
+		// Retry-After is capped by MaxDelay of the test.
+		{code: "v009", status: patcher.StatusUpdated, value: "Legal Nine", requests: 2},
+	}
+
+	statuses := h.statuses()
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if statuses[tt.code] != tt.status {
+				t.Errorf("status = %s, want %s", statuses[tt.code], tt.status)
+			}
+
+			if value, _ := h.localLegalName(tt.code); value != tt.value {
+				t.Errorf("local_legal_name = %q, want %q", value, tt.value)
+			}
+
+			if requests := h.vendorSrv.Requests(testGEID, tt.code); requests != tt.requests {
+				t.Errorf("requests = %v, want %v", requests, tt.requests)
+			}
+		})
+	}
+
+	entries, err := journal.Read(runDir(h.r.id), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("journal has %v entries, want 3 for the updated vendors", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Previous != nil {
+			t.Errorf("previous of %s = %q, want absent", entry.VendorCode, *entry.Previous)
+		}
+	}
+}
+
+func TestPatchAllIsolatesFailures(t *testing.T) {
+	h := newHarness(t)
+	h.r.errorBudget = errorbudget.Config{MaxErrors: 1}
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "", aws.String("Legal Two"))
+	for _, code := range []string{"t001", "t002"} {
+		h.addVendorIn("FP_TW", code, "", aws.String("Legal"))
+		h.vendorSrv.SetFault("FP_TW", code, vendorsrvtest.Fault{Status: http.StatusInternalServerError})
+	}
+
+	tw, err := utils.NewGlobalEntity("FP_TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := h.patchAll(false, h.globalEntity, tw)
+	if errs[0] != nil {
+		t.Errorf("patch of %s failed: %v", testGEID, errs[0])
+	}
+	if !errorbudget.IsExceeded(errs[1]) {
+		t.Errorf("err of FP_TW = %v, want its error budget exceeded", errs[1])
+	}
+
+	if statuses := h.statuses(); statuses["v001"] != patcher.StatusUpdated || statuses["v002"] != patcher.StatusUpdated {
+		t.Errorf("statuses of %s = %v, want both updated", testGEID, statuses)
+	}
+}
+
+func TestPatchAllAbortsOnFatalError(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(2)
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		h.addVendor(code, "", aws.String("Legal"))
+		h.vendorSrv.SetFault(testGEID, code, vendorsrvtest.Fault{Delay: 20 * time.Millisecond})
+	}
+	h.addVendorIn("FP_TW", "t001", "", aws.String("Legal Three"))
+	h.vendorSrv.SetFault("FP_TW", "t001", vendorsrvtest.Fault{Status: http.StatusUnauthorized})
+
+	tw, err := utils.NewGlobalEntity("FP_TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := h.patchAll(false, h.globalEntity, tw)
+	if !retryhttp.IsFatal(errs[1]) {
+		t.Errorf("err of FP_TW = %v, want the fatal error", errs[1])
+	}
+	if !retryhttp.IsFatal(errs[0]) || !strings.Contains(errs[0].Error(), "FP_TW") {
+		t.Errorf("err of %s = %v, want it aborted by the fatal error of FP_TW", testGEID, errs[0])
+	}
+
+	statuses := h.statuses()
+	if len(statuses) == 0 || len(statuses) == 10 {
+		t.Errorf("patched %v vendors of %s, want the ones in flight until FP_TW failed", len(statuses), testGEID)
+	}
+	for code, status := range statuses {
+		if status != patcher.StatusUpdated {
+			t.Errorf("status of %s = %s, want the vendor in flight to be updated", code, status)
+		}
+	}
+}
+
+func TestPatchErrorBudget(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(1)
+	h.r.errorBudget = errorbudget.Config{MaxErrors: 2}
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		h.addVendor(code, "", aws.String("Legal"))
+		h.vendorSrv.SetFault(testGEID, code, vendorsrvtest.Fault{Status: http.StatusInternalServerError})
+	}
+
+	err := h.patchAll(false, h.globalEntity)[0]
+	if !errorbudget.IsExceeded(err) {
+		t.Fatalf("err = %v, want the error budget exceeded", err)
+	}
+
+	if statuses := h.statuses(); len(statuses) != 3 {
+		t.Errorf("patched %v vendors, want the 3 until the budget is exceeded", len(statuses))
+	}
+}
+
+func TestPatchRunErrorBudget(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(2)
+	h.r.errorBudget = errorbudget.Config{MaxErrors: 1}
+	h.r.isRunErrorBudget = true
+	for i := 0; i < 20; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		h.addVendor(code, "", aws.String("Legal"))
+		h.vendorSrv.SetFault(testGEID, code, vendorsrvtest.Fault{Delay: 50 * time.Millisecond})
+	}
+	for i := 0; i < 3; i++ {
+		code := fmt.Sprintf("t%03d", i)
+		h.addVendorIn("FP_TW", code, "", aws.String("Legal"))
+		h.vendorSrv.SetFault("FP_TW", code, vendorsrvtest.Fault{Status: http.StatusInternalServerError})
+	}
+
+	tw, err := utils.NewGlobalEntity("FP_TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, err := range h.patchAll(false, h.globalEntity, tw) {
+		if !errorbudget.IsExceeded(err) {
+			t.Errorf("err %v = %v, want the error budget of the run exceeded", i, err)
+		}
+	}
+
+	if statuses := h.statuses(); len(statuses) == 20 {
+		t.Errorf("patched all vendors of %s, want them aborted by the failures of FP_TW", testGEID)
+	}
+}
+
+func TestBudgetShare(t *testing.T) {
+	b := newBudget(4)
+	for _, geid := range []string{"FP_SG", "FP_TW"} {
+		b.join(geid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := b.acquire(ctx, "FP_SG"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.acquire(ctx, "FP_SG"); err == nil {
+		t.Fatal("FP_SG took more than its share of 2")
+	}
+
+	b.leave("FP_TW")
+	if err := b.acquire(context.Background(), "FP_SG"); err != nil {
+		t.Fatalf("FP_SG should take the share of FP_TW once it left: %v", err)
+	}
+}
+
+func TestPatchMetrics(t *testing.T) {
+	h := newHarness(t)
+	h.r.metrics = metrics.New()
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "", aws.String("Legal Two"))
+	h.vendorSrv.SetFault(testGEID, "v002", vendorsrvtest.Fault{Status: http.StatusInternalServerError, Times: 1})
+
+	h.patch(false)
+	exportMetrics(h.r)
+
+	data, err := os.ReadFile(filepath.Join(runDir(h.r.id), metricsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := `env="staging",geid="FP_SG",target="local_legal_name"`
+	for _, line := range []string{
+		`dynamodb_patcher_vendors_total{env="staging",geid="FP_SG",outcome="updated",target="local_legal_name"} 2`,
+		`dynamodb_patcher_vendor_service_requests_total{code="200",` + labels + `} 2`,
+		`dynamodb_patcher_vendor_service_requests_total{code="500",` + labels + `} 1`,
+		`dynamodb_patcher_vendor_service_retries_total{` + labels + `} 1`,
+		`dynamodb_patcher_dynamodb_write_requests_total{env="staging",geid="FP_SG",operation="UpdateItem",outcome="ok",target="local_legal_name"} 2`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("metrics don't have %s", line)
+		}
+	}
+}
+
+func TestPatchLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatJSON, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	h := newHarness(t)
+	h.r.logger = runLogger(h.r.id, h.r.cfg.Env, h.r.target)
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "", aws.String("Legal Two"))
+	h.vendorSrv.SetFault(testGEID, "v002", vendorsrvtest.Fault{Status: http.StatusInternalServerError, Times: 3})
+
+	h.patch(false)
+
+	outcomes := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %s is not JSON: %v", line, err)
+		}
+		if record["msg"] != "Patched vendor" {
+			continue
+		}
+
+		for key, want := range map[string]string{"run_id": h.r.id, "env": "staging", "geid": testGEID, "target": localLegalName} {
+			if record[key] != want {
+				t.Errorf("%s of %s = %v, want %s", key, line, record[key], want)
+			}
+		}
+		if _, ok := record["duration"]; !ok {
+			t.Errorf("log line %s has no duration", line)
+		}
+		outcomes[record["vendor_code"].(string)] = record["outcome"].(string)
+	}
+
+	if outcomes["v001"] != string(patcher.StatusUpdated) || outcomes["v002"] != string(patcher.StatusFailedSource) {
+		t.Errorf("logged outcomes %v", outcomes)
+	}
+}
+
+func TestPatchProgress(t *testing.T) {
+	h := newHarness(t)
+	var out bytes.Buffer
+	h.r.progress = progress.New(&out, true, time.Hour, &stdoutMu)
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "Existing", aws.String("Legal Two"))
+	h.addVendor("v003", "", aws.String("Legal Three"))
+	h.vendorSrv.SetFault(testGEID, "v003", vendorsrvtest.Fault{Status: http.StatusInternalServerError, Times: 3})
+
+	h.patch(false)
+	h.r.progress.Render()
+
+	if bar := "3/3 100%  updated 1 skipped 1 failed 1  done in"; !strings.Contains(out.String(), bar) {
+		t.Errorf("progress is %q, want %q", out.String(), bar)
+	}
+}
+
+func TestPatchSourceFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		overwrite string
+		want      map[string]patcher.Status
+		wantValue string
+	}{
+		{
+			name: "set values are kept",
+			want: map[string]patcher.Status{
+				"v001": patcher.StatusUpdated,
+				"v002": patcher.StatusSkippedAlreadySet,
+				"v003": patcher.StatusSkippedAlreadySet,
+			},
+			wantValue: "Old",
+		},
+		{
+			name:      "set values are corrected",
+			overwrite: string(patcher.OverwriteIfDifferent),
+			want: map[string]patcher.Status{
+				"v001": patcher.StatusUpdated,
+				"v002": patcher.StatusUpdated,
+				"v003": patcher.StatusSkippedUnchanged,
+			},
+			wantValue: "Corrected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.addVendor("v001", "", nil)
+			h.addVendor("v002", "Old", nil)
+			h.addVendor("v003", "Same", nil)
+			h.addVendor("v004", "", aws.String("Legal Four"))
+
+			sourceFileFlag = filepath.Join(t.TempDir(), "corrections.csv")
+			content := "vendor_code,value\nv001,Legal One\nv002,Corrected\nv003,Same\nv999,Unknown\n"
+			if err := os.WriteFile(sourceFileFlag, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			overwriteFlag = tt.overwrite
+
+			h.patch(false)
+
+			if statuses := h.statuses(); !reflect.DeepEqual(statuses, tt.want) {
+				t.Errorf("statuses = %v, want %v and v004 not in the file left out", statuses, tt.want)
+			}
+			if value, _ := h.localLegalName("v002"); value != tt.wantValue {
+				t.Errorf("local_legal_name of v002 = %q, want %q", value, tt.wantValue)
+			}
+
+			var summary report.Summary
+			readJSON(t, filepath.Join(runDir(h.r.id), "summary-"+testGEID+".json"), &summary)
+			if !reflect.DeepEqual(summary.UnknownSourceCodes, []string{"v999"}) {
+				t.Errorf("unknown source codes = %v, want v999", summary.UnknownSourceCodes)
+			}
+		})
+	}
+}
+
+func TestValidateDiscoverGEIDsFlag(t *testing.T) {
+	newHarness(t)
+	geidsFlag = utils.GlobalEntitiesFlag{testGEID}
+	discoverGEIDsFlag = true
+	t.Cleanup(func() { geidsFlag, discoverGEIDsFlag = nil, false })
+
+	if err := validateRequiredFlags(nil); err == nil || !strings.Contains(err.Error(), "requires 'all' flag") {
+		t.Errorf("err = %v, want discover-geids rejected without all", err)
+	}
+
+	isForAllEntitiesFlag = true
+	t.Cleanup(func() { isForAllEntitiesFlag = false })
+	if err := validateRequiredFlags(nil); err != nil {
+		t.Errorf("err = %v, want discover-geids accepted with all", err)
+	}
+}
+
+func TestValidateOverwriteFlag(t *testing.T) {
+	newHarness(t)
+	isForAllEntitiesFlag = true
+	t.Cleanup(func() { isForAllEntitiesFlag = false })
+
+	overwriteFlag = "always"
+	if err := validateRequiredFlags(nil); err == nil || !strings.Contains(err.Error(), "overwrite flag should be") {
+		t.Errorf("err = %v, want overwrite always rejected", err)
+	}
+
+	overwriteFlag = string(patcher.OverwriteIfDifferent)
+	if err := validateRequiredFlags(nil); err != nil {
+		t.Errorf("err = %v, want overwrite if_different accepted", err)
+	}
+
+	targetFlag = ""
+	spec := &patcher.Spec{Name: "legal_name", Overwrite: patcher.OverwriteNever}
+	if err := validateRequiredFlags(spec); err == nil || !strings.Contains(err.Error(), "declare overwrite in the spec") {
+		t.Errorf("err = %v, want overwrite flag rejected with a spec", err)
+	}
+}
+
+func TestNewLoggerRejectsInvalidFlags(t *testing.T) {
+	if _, err := newLogger(io.Discard, "xml", "info"); err == nil {
+		t.Error("expected an error for log format xml")
+	}
+	if _, err := newLogger(io.Discard, logFormatText, "verbose"); err == nil {
+		t.Error("expected an error for log level verbose")
+	}
+}
+
+func TestPatchDryRun(t *testing.T) {
+	h := newHarness(t)
+	isDryRunFlag = true
+	t.Cleanup(func() { isDryRunFlag = false })
+
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "Existing", aws.String("Legal Two"))
+
+	h.patch(false)
+
+	if value, ok := h.localLegalName("v001"); ok {
+		t.Errorf("dry run wrote local_legal_name %q", value)
+	}
+
+	var changes []map[string]interface{}
+	readJSON(t, filepath.Join(runDir(h.r.id), "dry-run-"+testGEID+".json"), &changes)
+	if len(changes) != 1 || changes[0]["vendor_code"] != "v001" || changes[0]["proposed"] != "Legal One" {
+		t.Errorf("dry-run diff = %v, want the change of v001", changes)
+	}
+}
+
+func TestPatchResume(t *testing.T) {
+	h := newHarness(t)
+
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "", aws.String("Legal Two"))
+	h.vendorSrv.SetFault(testGEID, "v002", vendorsrvtest.Fault{Status: http.StatusBadGateway, Times: 3})
+
+	h.patch(false)
+	if status := h.statuses()["v002"]; status != patcher.StatusFailedSource {
+		t.Fatalf("status of v002 = %s, want %s before resume", status, patcher.StatusFailedSource)
+	}
+
+	h.patch(true)
+
+	if requests := h.vendorSrv.Requests(testGEID, "v001"); requests != 1 {
+		t.Errorf("requests of v001 = %v, want 1 as it's completed before resume", requests)
+	}
+	if value, _ := h.localLegalName("v002"); value != "Legal Two" {
+		t.Errorf("local_legal_name of v002 = %q, want it patched on resume", value)
+	}
+
+	var summary report.Summary
+	readJSON(t, filepath.Join(runDir(h.r.id), "summary-"+testGEID+".json"), &summary)
+	if summary.Total != 2 || summary.Resumed != 1 || summary.Counts[patcher.StatusUpdated] != 2 || summary.Counts[patcher.StatusFailedSource] != 0 {
+		t.Errorf("summary has total %v, resumed %v and counts %v, want both vendors updated", summary.Total, summary.Resumed, summary.Counts)
+	}
+}
+
+func TestPatchResumeMidPage(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(1)
+	memoryDB.PageSize = 3
+	for i := 0; i < 8; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		h.addVendor(code, "", aws.String("Legal "+code))
+	}
+	// the run stops on v004, in the middle of the second page of v003, v004 and v005.
+	h.vendorSrv.SetFault(testGEID, "v004", vendorsrvtest.Fault{Status: http.StatusUnauthorized, Times: 1})
+
+	if err := h.patchAll(false, h.globalEntity)[0]; !retryhttp.IsFatal(err) {
+		t.Fatalf("err = %v, want the run stopped on the fatal error of v004", err)
+	}
+	if statuses := h.statuses(); len(statuses) != 5 {
+		t.Fatalf("patched %v vendors, want v000 to v004 before the run stopped", len(statuses))
+	}
+
+	h.patch(true)
+
+	entries, err := journal.Read(runDir(h.r.id), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	journaled := map[string]int{}
+	for _, entry := range entries {
+		journaled[entry.VendorCode]++
+	}
+	for i := 0; i < 8; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		if journaled[code] != 1 {
+			t.Errorf("%s is journaled %v times, want once", code, journaled[code])
+		}
+		wantRequests := 1
+		if code == "v004" {
+			wantRequests = 2
+		}
+		if requests := h.vendorSrv.Requests(testGEID, code); requests != wantRequests {
+			t.Errorf("requests of %s = %v, want %v", code, requests, wantRequests)
+		}
+	}
+
+	// the first page is completed, so the resumed query starts from the second one and only v003 is skipped.
+	var summary report.Summary
+	readJSON(t, filepath.Join(runDir(h.r.id), "summary-"+testGEID+".json"), &summary)
+	if summary.Resumed != 1 || summary.Counts[patcher.StatusUpdated] != 8 {
+		t.Errorf("summary has resumed %v and counts %v, want v003 resumed and all vendors updated", summary.Resumed, summary.Counts)
+	}
+}
+
+func TestPatchGracefulShutdown(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(2)
+	memoryDB.PageSize = 2
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		h.addVendor(code, "", aws.String("Legal "+code))
+		h.vendorSrv.SetFault(testGEID, code, vendorsrvtest.Fault{Delay: 40 * time.Millisecond})
+	}
+
+	scheduleCtx, workCtx, stop := withShutdown(context.Background(), time.Minute)
+	defer stop()
+	// SIGINT is delivered while the first vendors are in flight, withShutdown keeps it from killing the test.
+	time.AfterFunc(60*time.Millisecond, func() {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+			t.Error(err)
+		}
+	})
+
+	err := h.patchWith(scheduleCtx, workCtx, false, h.globalEntity)[0]
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want the patch interrupted", err)
+	}
+
+	// the vendors in flight finish with workCtx, the pending ones are never started.
+	statuses := h.statuses()
+	if len(statuses) == 0 || len(statuses) == 10 {
+		t.Fatalf("patched %v vendors, want the ones started before SIGINT", len(statuses))
+	}
+	for code, status := range statuses {
+		if status != patcher.StatusUpdated {
+			t.Errorf("status of %s = %s, want the vendor in flight to finish", code, status)
+		}
+		if value, _ := h.localLegalName(code); value != "Legal "+code {
+			t.Errorf("local_legal_name of %s = %q, want it written", code, value)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		if _, ok := statuses[code]; !ok {
+			if value, ok := h.localLegalName(code); ok {
+				t.Errorf("local_legal_name of pending %s = %q, want it untouched", code, value)
+			}
+		}
+	}
+
+	entries, err := journal.Read(runDir(h.r.id), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(statuses) {
+		t.Errorf("journal has %v entries, want one per the %v vendors updated", len(entries), len(statuses))
+	}
+
+	// the resumed run patches the rest, a vendor completed before SIGINT is neither patched nor journaled again.
+	h.patch(true)
+	entries, err = journal.Read(runDir(h.r.id), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	journaled := map[string]int{}
+	for _, entry := range entries {
+		journaled[entry.VendorCode]++
+	}
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("v%03d", i)
+		if journaled[code] != 1 {
+			t.Errorf("%s is journaled %v times, want once", code, journaled[code])
+		}
+		if value, _ := h.localLegalName(code); value != "Legal "+code {
+			t.Errorf("local_legal_name of %s = %q after resume, want it written", code, value)
+		}
+	}
+}
+
+func TestPatchResumeCanary(t *testing.T) {
+	h := newHarness(t)
+
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "", aws.String("Legal Two"))
+	for _, code := range []string{"v003", "v004", "v005"} {
+		h.addVendor(code, "", aws.String("Legal "+code))
+		h.vendorSrv.SetFault(testGEID, code, vendorsrvtest.Fault{Status: http.StatusBadGateway, Times: 3})
+	}
+	h.patch(false)
+
+	// the vendors completed before the resume don't take the place of canary vendors.
+	h.r.canarySize = 2
+	h.patch(true)
+
+	var summary report.Summary
+	readJSON(t, filepath.Join(runDir(h.r.id), "summary-"+testGEID+".json"), &summary)
+	if summary.Canary == nil || summary.Canary.Size != 2 || !summary.Canary.Proceeded {
+		t.Fatalf("canary = %+v, want v003 and v004 verified", summary.Canary)
+	}
+	for _, code := range []string{"v003", "v004", "v005"} {
+		if value, _ := h.localLegalName(code); value != "Legal "+code {
+			t.Errorf("local_legal_name of %s = %q, want it patched on resume", code, value)
+		}
+	}
+}
+
+func TestAskRolloutReleasesOutput(t *testing.T) {
+	in, answers := io.Pipe()
+	defer answers.Close()
+	var out bytes.Buffer
+	canary := &report.Canary{Size: 10, ErrorRate: 20, MaxErrorRate: 5}
+
+	proceeded := make(chan bool)
+	go func() {
+		proceeded <- askRollout(bufio.NewReader(in), &out, testGEID, canary)
+	}()
+
+	// other GEIDs write log lines under stdoutMu while the operator thinks about the answer.
+	printed := make(chan struct{})
+	go func() {
+		for {
+			stdoutMu.Lock()
+			isPrinted := out.Len() > 0
+			stdoutMu.Unlock()
+			if isPrinted {
+				close(printed)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-printed:
+	case <-time.After(time.Second):
+		answers.Write([]byte("n\n"))
+		t.Fatal("stdoutMu is held while the prompt waits for an answer")
+	}
+
+	if _, err := answers.Write([]byte("yes\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !<-proceeded {
+		t.Error("yes is not read as a confirmation")
+	}
+	if !strings.Contains(out.String(), "Canary of FP_SG has error rate 20.0% over 5.0%") {
+		t.Errorf("prompt = %q", out.String())
+	}
+}
+
+func TestAskRolloutOneAtATime(t *testing.T) {
+	in, answers := io.Pipe()
+	defer answers.Close()
+	reader := bufio.NewReader(in)
+	var out bytes.Buffer
+
+	proceeded := make(chan bool, 2)
+	for _, geid := range []string{"FP_SG", "FP_TW"} {
+		go func(geid string) {
+			proceeded <- askRollout(reader, &out, geid, &report.Canary{})
+		}(geid)
+	}
+
+	for _, answer := range []string{"y\n", "n\n"} {
+		if _, err := answers.Write([]byte(answer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first, second := <-proceeded, <-proceeded; first == second {
+		t.Errorf("answers are %v and %v, want each prompt to read its own", first, second)
+	}
+	if prompts := strings.Count(out.String(), "verified no vendor"); prompts != 2 {
+		t.Errorf("printed %v prompts, want one per GEID: %q", prompts, out.String())
+	}
+}
+
+func TestPatchBatchWrites(t *testing.T) {
+	h := newHarness(t)
+	batchWritesFlag = true
+	h.r.budget = newBudget(8)
+
+	codes := []string{"v001", "v002", "v003", "v004", "v005", "v006", "v007", "v008", "v009", "v010"}
+	for _, code := range codes {
+		h.addVendor(code, "", aws.String("Legal "+code))
+	}
+
+	h.patch(false)
+
+	for _, code := range codes {
+		if value, _ := h.localLegalName(code); value != "Legal "+code {
+			t.Errorf("local_legal_name of %s = %q, want %q", code, value, "Legal "+code)
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	h := newHarness(t)
+
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "Existing", aws.String("Legal Two"))
+
+	h.patch(false)
+	saveBackend(h.r.id, memoryTablesFile)
+
+	runUndo([]string{
+		"-run", h.r.id,
+		"-output-dir", outputDirFlag,
+		"-backend", backendMemory,
+		"-fixture", filepath.Join(runDir(h.r.id), memoryTablesFile),
+	})
+
+	if value, ok := h.localLegalName("v001"); ok {
+		t.Errorf("local_legal_name of v001 = %q, want it removed by undo", value)
+	}
+	if value, _ := h.localLegalName("v002"); value != "Existing" {
+		t.Errorf("local_legal_name of v002 = %q, want it untouched", value)
+	}
+
+	records, err := vendorHistory(context.Background(), "", "v001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Target != localLegalName || records[1].Target != undoCommand {
+		t.Fatalf("history of v001 is %+v, want the patch and its undo", records)
+	}
+	if undo := records[1]; *undo.OldValue != "Legal One" || undo.NewValue != nil || undo.RunID != h.r.id {
+		t.Errorf("undo is audited as %+v, want Legal One removed", undo)
+	}
+}
+
+func TestUndoRecordedTable(t *testing.T) {
+	h := newHarness(t)
+
+	// the run writes to a table other than the one of staging, e.g. with PATCHER_DYNAMODB_TABLE_NAME.
+	h.r.cfg.AWS.DynamoDBTableName = "vendors-override"
+	memoryDB.CreateTable(h.r.cfg.AWS.DynamoDBTableName)
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.patch(false)
+	saveBackend(h.r.id, memoryTablesFile)
+
+	runUndo([]string{
+		"-run", h.r.id,
+		"-output-dir", outputDirFlag,
+		"-backend", backendMemory,
+		"-fixture", filepath.Join(runDir(h.r.id), memoryTablesFile),
+	})
+
+	if value, ok := h.localLegalName("v001"); ok {
+		t.Errorf("local_legal_name of v001 = %q, want it removed from the table of the run", value)
+	}
+}
+
+func TestUndoBatchWrites(t *testing.T) {
+	h := newHarness(t)
+
+	codes := []string{"v001", "v002", "v003"}
+	for _, code := range codes {
+		h.addVendor(code, "", aws.String("Legal "+code))
+	}
+	h.patch(false)
+
+	// the dine-in worker changes v002 after the run.
+	h.addVendor("v002", "Worker", nil)
+	saveBackend(h.r.id, memoryTablesFile)
+
+	runUndo([]string{
+		"-run", h.r.id,
+		"-output-dir", outputDirFlag,
+		"-backend", backendMemory,
+		"-fixture", filepath.Join(runDir(h.r.id), memoryTablesFile),
+		"-batch-writes",
+	})
+
+	for _, code := range []string{"v001", "v003"} {
+		if value, ok := h.localLegalName(code); ok {
+			t.Errorf("local_legal_name of %s = %q, want it removed by undo", code, value)
+		}
+	}
+	if value, _ := h.localLegalName("v002"); value != "Worker" {
+		t.Errorf("local_legal_name of v002 = %q, want the value of the worker kept", value)
+	}
+}
+
+func TestUndoWriteLimit(t *testing.T) {
+	h := newHarness(t)
+
+	codes := []string{"v001", "v002", "v003"}
+	for _, code := range codes {
+		h.addVendor(code, "", aws.String("Legal "+code))
+	}
+	h.patch(false)
+	saveBackend(h.r.id, memoryTablesFile)
+
+	start := time.Now()
+	runUndo([]string{
+		"-run", h.r.id,
+		"-output-dir", outputDirFlag,
+		"-backend", backendMemory,
+		"-fixture", filepath.Join(runDir(h.r.id), memoryTablesFile),
+		"-ddb-wps", "2",
+	})
+
+	// the burst of 2 writes is spent by the first restores, the third one waits for a token.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("undo took %v, want the third restore to wait for the write limit", elapsed)
+	}
+	for _, code := range codes {
+		if value, ok := h.localLegalName(code); ok {
+			t.Errorf("local_legal_name of %s = %q, want it removed by undo", code, value)
+		}
+	}
+}
+
+func TestPatchAuditFile(t *testing.T) {
+	h := newHarness(t)
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v002", "Existing", aws.String("Legal Two"))
+
+	h.patch(false)
+
+	records, err := audit.History(filepath.Join(outputDirFlag, audit.FileName), testGEID, "v001", slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tovendor.AuditRecord{
+		Operator:   "patcher@example.com",
+		RunID:      h.r.id,
+		Env:        "staging",
+		Target:     localLegalName,
+		GEID:       testGEID,
+		VendorCode: "v001",
+		Attribute:  tovendor.AttrLocalLegalName,
+		NewValue:   aws.String("Legal One"),
+	}
+	if len(records) != 1 {
+		t.Fatalf("audited %+v, want a record of v001", records)
+	}
+	if records[0].Time.IsZero() {
+		t.Error("audit record has no time")
+	}
+	records[0].Time = time.Time{}
+	if !reflect.DeepEqual(records[0], want) {
+		t.Errorf("audit record = %+v, want %+v", records[0], want)
+	}
+
+	if records, _ := audit.History(filepath.Join(outputDirFlag, audit.FileName), "", "v002", slog.Default()); len(records) != 0 {
+		t.Errorf("skipped v002 is audited: %+v", records)
+	}
+}
+
+func TestPatchAuditFailure(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("the audit file can't fail without /dev/full")
+	}
+
+	h := newHarness(t)
+	// every write to /dev/full fails with no space left on device.
+	auditFileFlag = "/dev/full"
+	h.addVendor("v001", "", aws.String("Legal One"))
+
+	h.patch(false)
+
+	if status := h.statuses()["v001"]; status != patcher.StatusUpdated {
+		t.Errorf("status of v001 = %s, want %s as only its audit failed", status, patcher.StatusUpdated)
+	}
+	if value, _ := h.localLegalName("v001"); value != "Legal One" {
+		t.Errorf("local_legal_name of v001 = %q, want Legal One", value)
+	}
+}
+
+func TestPatchJournalFailure(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("the journal can't fail without /dev/full")
+	}
+
+	h := newHarness(t)
+	h.r.budget = newBudget(1)
+	for i := 0; i < 3; i++ {
+		h.addVendor(fmt.Sprintf("v%03d", i), "", aws.String("Legal"))
+	}
+	// every append to /dev/full fails with no space left on device.
+	if err := os.MkdirAll(runDir(h.r.id), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/full", filepath.Join(runDir(h.r.id), "journal.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := h.patchAll(false, h.globalEntity)[0]
+	if !errors.Is(err, tovendor.ErrNotJournaled) {
+		t.Fatalf("err = %v, want the run stopped on the write that is not journaled", err)
+	}
+
+	statuses := h.statuses()
+	if len(statuses) != 1 || statuses["v000"] != patcher.StatusNotJournaled {
+		t.Errorf("statuses = %v, want only v000 %s", statuses, patcher.StatusNotJournaled)
+	}
+	if value, _ := h.localLegalName("v000"); value != "Legal" {
+		t.Errorf("local_legal_name of v000 = %q, want the written Legal", value)
+	}
+	// the write can't be undone, so its audit record is the only trace of the previous value.
+	if records, err := audit.History(filepath.Join(outputDirFlag, audit.FileName), testGEID, "v000", slog.Default()); err != nil || len(records) != 1 {
+		t.Errorf("audit records of v000 = %+v, %v, want the write audited", records, err)
+	}
+}
+
+func TestPatchAuditTable(t *testing.T) {
+	h := newHarness(t)
+	auditFlag = auditSinkTable
+	h.addVendor("v001", "", aws.String("Legal One"))
+	h.addVendor("v0010", "", aws.String("Legal Ten"))
+
+	h.patch(false)
+
+	records, err := vendorHistory(context.Background(), testGEID, "v001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].OldValue != nil || *records[0].NewValue != "Legal One" || records[0].Operator != "patcher@example.com" {
+		t.Fatalf("history of v001 is %+v", records)
+	}
+
+	var out bytes.Buffer
+	if err := writeHistory(&out, records); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "patcher@example.com  "+h.r.id) || !strings.Contains(out.String(), `absent  "Legal One"`) {
+		t.Errorf("history is printed as\n%s", out.String())
+	}
+
+	// audit items share the partition of the vendors without being read as vendors.
+	ddbClient, err := newDDBClient(h.r.cfg.AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := tovendor.NewDDBRepository(h.globalEntity, h.r.cfg, ddbClient)
+	if count, err := repo.CountVendors(context.Background()); err != nil || count != 2 {
+		t.Errorf("counted %v vendors, err: %v", count, err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDirFlag, audit.FileName)); !os.IsNotExist(err) {
+		t.Errorf("audit file is written with audit flag %s: %v", auditSinkTable, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	h := newHarness(t)
+
+	h.addVendor("v001", "Legal One", aws.String("Legal One"))
+	h.addVendor("v002", "Stale", aws.String("Legal Two"))
+	h.addVendor("v003", "", aws.String("Legal Three"))
+	h.addVendor("v004", "Legal Four", nil)
+	h.addVendor("v005", "Legal Five", aws.String("Legal Five"))
+	h.vendorSrv.SetFault(testGEID, "v005", vendorsrvtest.Fault{Status: http.StatusNotFound})
+	h.addVendor("v006", "", aws.String("Legal Six"))
+	h.vendorSrv.SetFault(testGEID, "v006", vendorsrvtest.Fault{Status: http.StatusInternalServerError})
+
+	audit, err := verify(context.Background(), context.Background(), h.r, h.globalEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v004 has no value in vendor service and vendor service doesn't know v005.
+	want := map[report.Class]int{
+		report.ClassInSync:          1,
+		report.ClassMismatched:      1,
+		report.ClassMissingInTable:  1,
+		report.ClassMissingInSource: 2,
+		report.ClassSourceError:     1,
+	}
+	for class, count := range want {
+		if audit.Counts[class] != count {
+			t.Errorf("%s = %v, want %v", class, audit.Counts[class], count)
+		}
+	}
+}
+
+func TestVerifyAbortsOnFatalError(t *testing.T) {
+	h := newHarness(t)
+	h.r.budget = newBudget(1)
+	for i := 0; i < 5; i++ {
+		h.addVendor(fmt.Sprintf("v%03d", i), "", aws.String("Legal"))
+	}
+	h.vendorSrv.SetFault(testGEID, "v001", vendorsrvtest.Fault{Status: http.StatusUnauthorized})
+
+	audit, err := verify(context.Background(), context.Background(), h.r, h.globalEntity)
+	if !retryhttp.IsFatal(err) {
+		t.Fatalf("err = %v, want the fatal error", err)
+	}
+	if audit.Total != 2 {
+		t.Errorf("verified %v vendors, want the 2 until the fatal error", audit.Total)
+	}
+}
+
+func TestDiscoverGEIDs(t *testing.T) {
+	h := newHarness(t)
+	h.addVendor("v001", "", nil)
+	memoryDB.PageSize = 1
+
+	table := h.r.cfg.AWS.DynamoDBTableName
+	for _, it := range []map[string]types.AttributeValue{
+		{
+			"PK":          &types.AttributeValueMemberS{Value: "GEID#FP_TW"},
+			"SK":          &types.AttributeValueMemberS{Value: "GEID#FP_TW,VENDOR#t001"},
+			"vendor_code": &types.AttributeValueMemberS{Value: "t001"},
+		},
+		{
+			"PK": &types.AttributeValueMemberS{Value: "GEID#FP_HK"},
+			"SK": &types.AttributeValueMemberS{Value: "GEID#FP_HK,SETTINGS"},
+		},
+	} {
+		if err := memoryDB.Put(table, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.r.cfg.GEIDs = []string{"FP_HK", "FP_SG"}
+	geids, err := discoverGEIDs(context.Background(), h.r.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"FP_SG", "FP_TW"}; !reflect.DeepEqual(geids, want) {
+		t.Errorf("geids = %v, want %v", geids, want)
+	}
+}
+
+func TestVendorServiceRejectsBadToken(t *testing.T) {
+	h := newHarness(t)
+	h.addVendor("v001", "", aws.String("Legal One"))
+	t.Setenv("VENDOR_SERVICE_TOKEN", "wrong-token")
+
+	src := source.NewVendorService(vendorSrv.NewClient(h.globalEntity, h.r.cfg, h.r.httpClient), patcher.LocalLegalNameSourceField)
+	_, err := src.Lookup(context.Background(), "v001")
+	if !retryhttp.IsFatal(err) {
+		t.Errorf("err = %v, want a fatal error", err)
+	}
+	if requests := h.vendorSrv.Requests(testGEID, "v001"); requests != 1 {
+		t.Errorf("requests = %v, want 1 as a bad token is not retried", requests)
+	}
+}
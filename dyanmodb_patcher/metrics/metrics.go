@@ -0,0 +1,207 @@
+// Package metrics exports the Prometheus metrics of a patch run on an HTTP endpoint, to a textfile of the node
+// exporter or to a pushgateway.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "dynamodb_patcher"
+
+// patchLabels are the labels of every metric of a patch.
+var patchLabels = []string{"geid", "env", "target"}
+
+// Labels identify the patch of a GEID.
+type Labels struct {
+	GEID   string
+	Env    string
+	Target string
+}
+
+func (l Labels) values(extra ...string) []string {
+	return append([]string{l.GEID, l.Env, l.Target}, extra...)
+}
+
+// Metrics are the collectors of a run, they are registered to a registry of their own so that only the metrics of
+// the patcher are exported. A nil Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	vendors           *prometheus.CounterVec
+	vendorSrvRequests *prometheus.CounterVec
+	vendorSrvDuration *prometheus.HistogramVec
+	vendorSrvRetries  *prometheus.CounterVec
+	ddbRequests       *prometheus.CounterVec
+	ddbDuration       *prometheus.HistogramVec
+	ddbCapacity       *prometheus.CounterVec
+	ddbRetries        *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		vendors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "vendors_total",
+			Help:      "Vendors processed by outcome.",
+		}, append(patchLabels, "outcome")),
+		vendorSrvRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "vendor_service",
+			Name:      "requests_total",
+			Help:      "Attempts of vendor service requests by status code, error for the ones without a response.",
+		}, append(patchLabels, "code")),
+		vendorSrvDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "vendor_service",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the attempts of vendor service requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, patchLabels),
+		vendorSrvRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "vendor_service",
+			Name:      "retries_total",
+			Help:      "Retried attempts of vendor service requests.",
+		}, patchLabels),
+		ddbRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "write_requests_total",
+			Help:      "DynamoDB write requests by operation and outcome.",
+		}, append(patchLabels, "operation", "outcome")),
+		ddbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "write_duration_seconds",
+			Help:      "Latency of DynamoDB write requests including the retries of the SDK.",
+			Buckets:   prometheus.DefBuckets,
+		}, append(patchLabels, "operation")),
+		ddbCapacity: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "consumed_capacity_units_total",
+			Help:      "Write capacity units consumed by DynamoDB write requests.",
+		}, append(patchLabels, "operation")),
+		ddbRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "retries_total",
+			Help:      "Retries of DynamoDB write requests, by the SDK or for unprocessed items and conflicting transactions.",
+		}, append(patchLabels, "operation")),
+	}
+
+	m.registry.MustRegister(
+		m.vendors,
+		m.vendorSrvRequests,
+		m.vendorSrvDuration,
+		m.vendorSrvRetries,
+		m.ddbRequests,
+		m.ddbDuration,
+		m.ddbCapacity,
+		m.ddbRetries,
+	)
+	return m
+}
+
+// For returns the recorder of the patch identified by labels.
+func (m *Metrics) For(labels Labels) *Recorder {
+	if m == nil {
+		return nil
+	}
+	return &Recorder{metrics: m, labels: labels}
+}
+
+// Serve exposes the metrics on addr, e.g. :9090, until the returned server is closed. A server stopped on an error
+// is logged to logger.
+func (m *Metrics) Serve(addr string, logger *slog.Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// WriteTextfile saves the metrics in the text format, e.g. for the textfile collector of the node exporter.
+func (m *Metrics) WriteTextfile(path string) error {
+	if err := prometheus.WriteToTextfile(path, m.registry); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Push replaces the metrics of the run in the pushgateway at url, they are grouped by run id.
+func (m *Metrics) Push(url, runID string) error {
+	if err := push.New(url, namespace).Gatherer(m.registry).Grouping("run_id", runID).Push(); err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	return nil
+}
+
+// Recorder records the metrics of the patch of a GEID, it implements the observers of retryhttp and dynamodb.
+// A nil Recorder records nothing.
+type Recorder struct {
+	metrics *Metrics
+	labels  Labels
+}
+
+// ObserveVendor counts a vendor processed with outcome, e.g. updated.
+func (r *Recorder) ObserveVendor(outcome string) {
+	if r == nil {
+		return
+	}
+	r.metrics.vendors.WithLabelValues(r.labels.values(outcome)...).Inc()
+}
+
+// ObserveAttempt records an attempt of a vendor service request, statusCode is 0 when there is no response.
+func (r *Recorder) ObserveAttempt(attempt, statusCode int, duration time.Duration) {
+	if r == nil {
+		return
+	}
+
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	r.metrics.vendorSrvRequests.WithLabelValues(r.labels.values(code)...).Inc()
+	r.metrics.vendorSrvDuration.WithLabelValues(r.labels.values()...).Observe(duration.Seconds())
+	if attempt > 0 {
+		r.metrics.vendorSrvRetries.WithLabelValues(r.labels.values()...).Inc()
+	}
+}
+
+// ObserveWrite records a DynamoDB write request.
+func (r *Recorder) ObserveWrite(operation string, duration time.Duration, consumedCapacity float64, retries int, err error) {
+	if r == nil {
+		return
+	}
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	r.metrics.ddbRequests.WithLabelValues(r.labels.values(operation, outcome)...).Inc()
+	r.metrics.ddbDuration.WithLabelValues(r.labels.values(operation)...).Observe(duration.Seconds())
+	r.metrics.ddbCapacity.WithLabelValues(r.labels.values(operation)...).Add(consumedCapacity)
+	r.metrics.ddbRetries.WithLabelValues(r.labels.values(operation)...).Add(float64(retries))
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMetrics() *Metrics {
+	m := New()
+	recorder := m.For(Labels{GEID: "FP_SG", Env: "staging", Target: "local_legal_name"})
+	recorder.ObserveVendor("updated")
+	recorder.ObserveVendor("updated")
+	recorder.ObserveAttempt(0, http.StatusBadGateway, 20*time.Millisecond)
+	recorder.ObserveAttempt(1, 0, time.Second)
+	recorder.ObserveWrite("UpdateItem", 5*time.Millisecond, 1.5, 2, nil)
+	recorder.ObserveWrite("UpdateItem", 5*time.Millisecond, 0, 0, errors.New("throttled"))
+	return m
+}
+
+func TestWriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	if err := newTestMetrics().WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`dynamodb_patcher_vendors_total{env="staging",geid="FP_SG",outcome="updated",target="local_legal_name"} 2`,
+		`dynamodb_patcher_vendor_service_requests_total{code="502",env="staging",geid="FP_SG",target="local_legal_name"} 1`,
+		`dynamodb_patcher_vendor_service_requests_total{code="error",env="staging",geid="FP_SG",target="local_legal_name"} 1`,
+		`dynamodb_patcher_vendor_service_retries_total{env="staging",geid="FP_SG",target="local_legal_name"} 1`,
+		`dynamodb_patcher_dynamodb_write_requests_total{env="staging",geid="FP_SG",operation="UpdateItem",outcome="error",target="local_legal_name"} 1`,
+		`dynamodb_patcher_dynamodb_consumed_capacity_units_total{env="staging",geid="FP_SG",operation="UpdateItem",target="local_legal_name"} 1.5`,
+		`dynamodb_patcher_dynamodb_retries_total{env="staging",geid="FP_SG",operation="UpdateItem",target="local_legal_name"} 2`,
+		`dynamodb_patcher_dynamodb_write_duration_seconds_count{env="staging",geid="FP_SG",operation="UpdateItem",target="local_legal_name"} 2`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("textfile doesn't have %s", line)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	var mu sync.Mutex
+	var method, path, body string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		method, path, body = r.Method, r.URL.Path, string(data)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushgateway.Close()
+
+	if err := newTestMetrics().Push(pushgateway.URL, "20261018-000000-abcd"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if method != http.MethodPut || path != "/metrics/job/dynamodb_patcher/run_id/20261018-000000-abcd" {
+		t.Errorf("pushed with %s %s", method, path)
+	}
+	if body == "" {
+		t.Error("pushed no metrics")
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var m *Metrics
+	recorder := m.For(Labels{GEID: "FP_SG"})
+	recorder.ObserveVendor("updated")
+	recorder.ObserveAttempt(0, http.StatusOK, time.Millisecond)
+	recorder.ObserveWrite("UpdateItem", time.Millisecond, 1, 0, nil)
+}
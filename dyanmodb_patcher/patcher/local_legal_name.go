@@ -2,50 +2,132 @@ package patcher
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
 	vendorSrv "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
 )
 
 type vendorRepository interface {
 	GetAllVendors(ctx context.Context) ([]tovendor.Vendor, error)
-	UpdateLocalLegalName(ctx context.Context, vendorCode, localLegalName string) error
+	UpdateAttribute(ctx context.Context, change tovendor.Change) error
 }
 
+// LocalLegalNameSourceField is the vendor service field of the local legal name.
+// account_name_localized from vendor service = local legal name we pass to cybersource
+const LocalLegalNameSourceField = "account_name_localized"
+
 type LocalLegalNamePatcher struct {
 	vendorRepository vendorRepository
-	vendorSrvClient  *vendorSrv.Client
+	source           source.Source
+	overwrite        OverwritePolicy
 }
 
-func (p *LocalLegalNamePatcher) Patch(ctx context.Context, vendor tovendor.Vendor) {
+func (p *LocalLegalNamePatcher) Patch(ctx context.Context, vendor tovendor.Vendor) Result {
+	result := Result{
+		VendorCode: vendor.Code,
+		Attribute:  tovendor.AttrLocalLegalName,
+		Current:    vendor.LocalLegalName,
+	}
+
 	// it is already updated by dine in worker.
-	if vendor.LocalLegalName != "" {
-		return
+	if vendor.LocalLegalName != "" && p.overwrite == OverwriteNever {
+		result.Status = StatusSkippedAlreadySet
+		return result
 	}
 
-	localLegalName, err := p.vendorSrvClient.GetLocalLegalName(vendor.Code)
+	localLegalName, err := p.source.Lookup(ctx, vendor.Code)
+	// the vendor is in the table but vendor service doesn't know it, retrying won't help.
+	if retryhttp.IsNotFound(err) {
+		result.Status = StatusSkippedNotFound
+		result.Err = err
+		return result
+	}
 	if err != nil {
-		log.Printf("failed to get vendor local name, vendor code: %s, err: %v", vendor.Code, err)
-		return
+		result.Status = StatusFailedSource
+		result.Err = fmt.Errorf("failed to get vendor local name: %w", err)
+		return result
 	}
 
 	if localLegalName == "" {
-		log.Printf("vendor %s does not have local legal name\n", vendor.Code)
-		return
+		result.Status = StatusSkippedNoSourceValue
+		return result
+	}
+
+	if localLegalName == vendor.LocalLegalName {
+		result.Status = StatusSkippedUnchanged
+		return result
+	}
+
+	result.Proposed = localLegalName
+
+	reason := fmt.Sprintf("local_legal_name is empty, use the value from %s", p.source)
+	if vendor.LocalLegalName != "" {
+		reason = fmt.Sprintf("local_legal_name differs from %s", p.source)
+	}
+
+	err = p.vendorRepository.UpdateAttribute(ctx, tovendor.Change{
+		VendorCode: vendor.Code,
+		Attribute:  tovendor.AttrLocalLegalName,
+		Current:    vendor.LocalLegalName,
+		Proposed:   localLegalName,
+		Reason:     reason,
+	})
+	if errors.Is(err, tovendor.ErrConcurrentlyModified) {
+		result.Status = StatusConcurrentlyModified
+		result.Err = err
+		return result
+	}
+	if errors.Is(err, tovendor.ErrNotWritten) {
+		result.Status = StatusNotWritten
+		result.Err = err
+		return result
+	}
+	if errors.Is(err, tovendor.ErrNotJournaled) {
+		result.Status = StatusNotJournaled
+		result.Err = err
+		return result
 	}
+	if err != nil {
+		result.Status = StatusFailedWrite
+		result.Err = fmt.Errorf("failed to update vendor local name: %w", err)
+		return result
+	}
+
+	result.Status = StatusUpdated
+	return result
+}
+
+// Attribute returns the vendor attribute the patcher writes.
+func (p *LocalLegalNamePatcher) Attribute() string {
+	return tovendor.AttrLocalLegalName
+}
 
-	log.Printf("%s, %s, %s\n", vendor.Code, vendor.Name, localLegalName)
-	p.vendorRepository.UpdateLocalLegalName(ctx, vendor.Code, localLegalName)
+// Source returns where the patcher reads the values from.
+func (p *LocalLegalNamePatcher) Source() source.Source {
+	return p.source
 }
 
 func (p *LocalLegalNamePatcher) ValidateEnvConfig() error {
-	return p.vendorSrvClient.ValidateEnvConfig()
+	return p.source.ValidateEnvConfig()
 }
 
-func NewLocalLegalNamePatcher(vendorRepo vendorRepository, vendorSrvClient *vendorSrv.Client) *LocalLegalNamePatcher {
+// NewLocalLegalNamePatcher returns a patcher reading the local legal name from src, or from vendor service when
+// src is nil. Local legal names already set are written as overwrite tells, it defaults to OverwriteNever.
+func NewLocalLegalNamePatcher(vendorRepo vendorRepository, vendorSrvClient *vendorSrv.Client, src source.Source, overwrite OverwritePolicy) *LocalLegalNamePatcher {
+	if src == nil {
+		src = source.NewVendorService(vendorSrvClient, LocalLegalNameSourceField)
+	}
+	if overwrite == "" {
+		overwrite = OverwriteNever
+	}
+
 	return &LocalLegalNamePatcher{
 		vendorRepository: vendorRepo,
-		vendorSrvClient:  vendorSrvClient,
+		source:           src,
+		overwrite:        overwrite,
 	}
 }
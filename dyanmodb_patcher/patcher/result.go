@@ -0,0 +1,55 @@
+package patcher
+
+type Status string
+
+const (
+	StatusUpdated              Status = "updated"
+	StatusSkippedAlreadySet    Status = "skipped_already_set"
+	StatusSkippedNoSourceValue Status = "skipped_no_source_value"
+	StatusSkippedByCondition   Status = "skipped_by_condition"
+	StatusSkippedUnchanged     Status = "skipped_unchanged"
+	StatusSkippedNotFound      Status = "skipped_vendor_not_found"
+	StatusConcurrentlyModified Status = "concurrently_modified"
+	StatusNotWritten           Status = "not_written"
+	StatusFailedSource         Status = "failed_source"
+	StatusFailedWrite          Status = "failed_write"
+	StatusNotJournaled         Status = "updated_not_journaled"
+)
+
+// Statuses lists all statuses in the order they are reported.
+var Statuses = []Status{
+	StatusUpdated,
+	StatusSkippedAlreadySet,
+	StatusSkippedNoSourceValue,
+	StatusSkippedByCondition,
+	StatusSkippedUnchanged,
+	StatusSkippedNotFound,
+	StatusConcurrentlyModified,
+	StatusNotWritten,
+	StatusFailedSource,
+	StatusFailedWrite,
+	StatusNotJournaled,
+}
+
+// IsCompleted reports whether the vendor needs no more work, i.e. a resumed run can skip it.
+func (s Status) IsCompleted() bool {
+	switch s {
+	case StatusUpdated, StatusSkippedAlreadySet, StatusSkippedNoSourceValue, StatusSkippedByCondition, StatusSkippedUnchanged, StatusSkippedNotFound:
+		return true
+	}
+	return false
+}
+
+func (s Status) IsFailed() bool {
+	return s == StatusFailedSource || s == StatusFailedWrite || s == StatusNotJournaled
+}
+
+// Result is the outcome of patching a single vendor.
+type Result struct {
+	VendorCode string
+	Attribute  string
+	Status     Status
+	Current    string
+	Proposed   string
+	Err        error
+}
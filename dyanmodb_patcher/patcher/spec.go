@@ -0,0 +1,255 @@
+package patcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
+	vendorSrv "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
+)
+
+// OverwritePolicy tells when a spec patcher writes a target attribute that already has a value.
+type OverwritePolicy string
+
+const (
+	// OverwriteNever only writes attributes that are empty, it's the default policy.
+	OverwriteNever OverwritePolicy = "never"
+	// OverwriteIfDifferent writes attributes whose value differs from the source.
+	OverwriteIfDifferent OverwritePolicy = "if_different"
+)
+
+const (
+	conditionEmpty    = "empty"
+	conditionNotEmpty = "not_empty"
+)
+
+// Spec declares a routine backfill: read a field from vendor service and write it to an attribute of the vendor item.
+//
+//	name: local_legal_name
+//	source:
+//	  json_path: account_name_localized
+//	target:
+//	  attribute: local_legal_name
+//	skip_if:
+//	  - attribute: name
+//	    is: empty
+//	overwrite: never
+type Spec struct {
+	Name      string          `yaml:"name"`
+	Source    SpecSource      `yaml:"source"`
+	Target    SpecTarget      `yaml:"target"`
+	SkipIf    []SkipCondition `yaml:"skip_if"`
+	Overwrite OverwritePolicy `yaml:"overwrite"`
+}
+
+type SpecSource struct {
+	// JSONPath is the dot separated path of the field in the vendor service response.
+	// It's ignored when the patcher is given a source like a file.
+	JSONPath string `yaml:"json_path"`
+}
+
+type SpecTarget struct {
+	Attribute string `yaml:"attribute"`
+}
+
+// SkipCondition skips a vendor when its attribute is empty or not_empty.
+type SkipCondition struct {
+	Attribute string `yaml:"attribute"`
+	Is        string `yaml:"is"`
+}
+
+func (c SkipCondition) matches(vendor tovendor.Vendor) bool {
+	isEmpty := vendor.Attribute(c.Attribute) == ""
+	return (c.Is == conditionEmpty) == isEmpty
+}
+
+// LoadSpec reads a YAML or JSON spec file.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec: %w", err)
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec %s: %w", path, err)
+	}
+
+	if spec.Overwrite == "" {
+		spec.Overwrite = OverwriteNever
+	}
+
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+
+	return &spec, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if s.Source.JSONPath == "" {
+		return errors.New("source.json_path is required")
+	}
+
+	if s.Target.Attribute == "" {
+		return errors.New("target.attribute is required")
+	}
+
+	if s.Overwrite != OverwriteNever && s.Overwrite != OverwriteIfDifferent {
+		return fmt.Errorf("unsupported overwrite policy %q", s.Overwrite)
+	}
+
+	for _, condition := range s.SkipIf {
+		if condition.Attribute == "" {
+			return errors.New("skip_if.attribute is required")
+		}
+		if condition.Is != conditionEmpty && condition.Is != conditionNotEmpty {
+			return fmt.Errorf("unsupported skip_if check %q of %s", condition.Is, condition.Attribute)
+		}
+	}
+
+	return nil
+}
+
+// Digest identifies what the spec patches, specs that patch the same have the same digest.
+func (s *Spec) Digest() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", *s)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Attributes returns the vendor attributes the spec reads.
+func (s *Spec) Attributes() []string {
+	attributes := []string{s.Target.Attribute}
+	for _, condition := range s.SkipIf {
+		attributes = append(attributes, condition.Attribute)
+	}
+	return attributes
+}
+
+// SpecPatcher patches the target of a Spec, so routine backfills don't need a patcher of their own.
+type SpecPatcher struct {
+	spec             *Spec
+	vendorRepository vendorRepository
+	source           source.Source
+}
+
+func (p *SpecPatcher) Patch(ctx context.Context, vendor tovendor.Vendor) Result {
+	current := vendor.Attribute(p.spec.Target.Attribute)
+	result := Result{
+		VendorCode: vendor.Code,
+		Attribute:  p.spec.Target.Attribute,
+		Current:    current,
+	}
+
+	for _, condition := range p.spec.SkipIf {
+		if condition.matches(vendor) {
+			result.Status = StatusSkippedByCondition
+			return result
+		}
+	}
+
+	if current != "" && p.spec.Overwrite == OverwriteNever {
+		result.Status = StatusSkippedAlreadySet
+		return result
+	}
+
+	value, err := p.source.Lookup(ctx, vendor.Code)
+	if retryhttp.IsNotFound(err) {
+		result.Status = StatusSkippedNotFound
+		result.Err = err
+		return result
+	}
+	if err != nil {
+		result.Status = StatusFailedSource
+		result.Err = fmt.Errorf("failed to get value from %s: %w", p.source, err)
+		return result
+	}
+
+	if value == "" {
+		result.Status = StatusSkippedNoSourceValue
+		return result
+	}
+
+	if value == current {
+		result.Status = StatusSkippedUnchanged
+		return result
+	}
+
+	result.Proposed = value
+
+	reason := fmt.Sprintf("%s is empty, use the value from %s", p.spec.Target.Attribute, p.source)
+	if current != "" {
+		reason = fmt.Sprintf("%s differs from %s", p.spec.Target.Attribute, p.source)
+	}
+
+	err = p.vendorRepository.UpdateAttribute(ctx, tovendor.Change{
+		VendorCode: vendor.Code,
+		Attribute:  p.spec.Target.Attribute,
+		Current:    current,
+		Proposed:   value,
+		Reason:     reason,
+	})
+	if errors.Is(err, tovendor.ErrConcurrentlyModified) {
+		result.Status = StatusConcurrentlyModified
+		result.Err = err
+		return result
+	}
+	if errors.Is(err, tovendor.ErrNotWritten) {
+		result.Status = StatusNotWritten
+		result.Err = err
+		return result
+	}
+	if errors.Is(err, tovendor.ErrNotJournaled) {
+		result.Status = StatusNotJournaled
+		result.Err = err
+		return result
+	}
+	if err != nil {
+		result.Status = StatusFailedWrite
+		result.Err = fmt.Errorf("failed to update %s: %w", p.spec.Target.Attribute, err)
+		return result
+	}
+
+	result.Status = StatusUpdated
+	return result
+}
+
+// Attribute returns the vendor attribute the patcher writes.
+func (p *SpecPatcher) Attribute() string {
+	return p.spec.Target.Attribute
+}
+
+// Source returns where the patcher reads the values from.
+func (p *SpecPatcher) Source() source.Source {
+	return p.source
+}
+
+func (p *SpecPatcher) ValidateEnvConfig() error {
+	return p.source.ValidateEnvConfig()
+}
+
+// NewSpecPatcher returns a patcher of spec reading values from src, or from the source.json_path field of
+// vendor service when src is nil.
+func NewSpecPatcher(spec *Spec, vendorRepo vendorRepository, vendorSrvClient *vendorSrv.Client, src source.Source) *SpecPatcher {
+	if src == nil {
+		src = source.NewVendorService(vendorSrvClient, spec.Source.JSONPath)
+	}
+
+	return &SpecPatcher{
+		spec:             spec,
+		vendorRepository: vendorRepo,
+		source:           src,
+	}
+}
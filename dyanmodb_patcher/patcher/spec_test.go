@@ -0,0 +1,229 @@
+package patcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// fakeRepository records the changes it's asked for and fails them with err.
+type fakeRepository struct {
+	changes []tovendor.Change
+	err     error
+}
+
+func (r *fakeRepository) GetAllVendors(context.Context) ([]tovendor.Vendor, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) UpdateAttribute(_ context.Context, change tovendor.Change) error {
+	r.changes = append(r.changes, change)
+	return r.err
+}
+
+// fakeSource returns the value of a vendor, or its error.
+type fakeSource struct {
+	values map[string]string
+	errs   map[string]error
+}
+
+func (s fakeSource) Lookup(_ context.Context, vendorCode string) (string, error) {
+	return s.values[vendorCode], s.errs[vendorCode]
+}
+
+func (s fakeSource) ValidateEnvConfig() error {
+	return nil
+}
+
+func (s fakeSource) String() string {
+	return "fake source"
+}
+
+func writeSpec(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSpec(t *testing.T) {
+	spec, err := LoadSpec(filepath.Join("..", "specs", "local_legal_name.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Name != "local_legal_name_spec" || spec.Source.JSONPath != "account_name_localized" || spec.Target.Attribute != "local_legal_name" {
+		t.Errorf("spec = %+v", spec)
+	}
+	if spec.Overwrite != OverwriteNever {
+		t.Errorf("overwrite = %q, want %q", spec.Overwrite, OverwriteNever)
+	}
+	if attributes := spec.Attributes(); len(attributes) != 2 || attributes[0] != "local_legal_name" || attributes[1] != "name" {
+		t.Errorf("attributes = %v, want the target and the skip_if attributes", attributes)
+	}
+}
+
+func TestLoadSpecJSON(t *testing.T) {
+	path := writeSpec(t, `{"name": "legal_name", "source": {"json_path": "legal.name"}, "target": {"attribute": "legal_name"}, "overwrite": "if_different"}`)
+
+	spec, err := LoadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Source.JSONPath != "legal.name" || spec.Overwrite != OverwriteIfDifferent {
+		t.Errorf("spec = %+v", spec)
+	}
+}
+
+func TestLoadSpecInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "malformed",
+			content: "name: [",
+			wantErr: "failed to parse spec",
+		},
+		{
+			name:    "no name",
+			content: "source: {json_path: a}\ntarget: {attribute: a}",
+			wantErr: "name is required",
+		},
+		{
+			name:    "no source",
+			content: "name: a\ntarget: {attribute: a}",
+			wantErr: "source.json_path is required",
+		},
+		{
+			name:    "no target",
+			content: "name: a\nsource: {json_path: a}",
+			wantErr: "target.attribute is required",
+		},
+		{
+			name:    "unknown overwrite",
+			content: "name: a\nsource: {json_path: a}\ntarget: {attribute: a}\noverwrite: always",
+			wantErr: `unsupported overwrite policy "always"`,
+		},
+		{
+			name:    "skip_if without attribute",
+			content: "name: a\nsource: {json_path: a}\ntarget: {attribute: a}\nskip_if: [{is: empty}]",
+			wantErr: "skip_if.attribute is required",
+		},
+		{
+			name:    "unknown skip_if check",
+			content: "name: a\nsource: {json_path: a}\ntarget: {attribute: a}\nskip_if: [{attribute: name, is: blank}]",
+			wantErr: `unsupported skip_if check "blank" of name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSpec(writeSpec(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadSpec(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "failed to read spec") {
+		t.Errorf("err = %v, want the missing file reported", err)
+	}
+}
+
+func TestSpecDigest(t *testing.T) {
+	spec := Spec{Name: "a", Source: SpecSource{JSONPath: "a"}, Target: SpecTarget{Attribute: "a"}, Overwrite: OverwriteNever}
+	same := spec
+	other := spec
+	other.Overwrite = OverwriteIfDifferent
+
+	if spec.Digest() != same.Digest() {
+		t.Error("the digests of the same spec differ")
+	}
+	if spec.Digest() == other.Digest() {
+		t.Error("the digests of specs with different overwrite policies are the same")
+	}
+}
+
+func TestSpecPatcher(t *testing.T) {
+	notFound := &retryhttp.StatusError{StatusCode: http.StatusNotFound, Class: retryhttp.ClassPermanent}
+
+	tests := []struct {
+		name      string
+		overwrite OverwritePolicy
+		current   string
+		noName    bool
+		value     string
+		lookupErr error
+		writeErr  error
+		want      Status
+	}{
+		{name: "empty attribute", overwrite: OverwriteNever, value: "Legal", want: StatusUpdated},
+		{name: "set attribute is kept", overwrite: OverwriteNever, current: "Old", value: "Legal", want: StatusSkippedAlreadySet},
+		{name: "set attribute is overwritten", overwrite: OverwriteIfDifferent, current: "Old", value: "Legal", want: StatusUpdated},
+		{name: "same value is not written", overwrite: OverwriteIfDifferent, current: "Legal", value: "Legal", want: StatusSkippedUnchanged},
+		{name: "empty source value", overwrite: OverwriteIfDifferent, current: "Old", want: StatusSkippedNoSourceValue},
+		{name: "skip condition", overwrite: OverwriteNever, noName: true, value: "Legal", want: StatusSkippedByCondition},
+		{name: "vendor not in source", overwrite: OverwriteNever, lookupErr: notFound, want: StatusSkippedNotFound},
+		{name: "source failure", overwrite: OverwriteNever, lookupErr: errors.New("timeout"), want: StatusFailedSource},
+		{name: "concurrent write", overwrite: OverwriteNever, value: "Legal", writeErr: tovendor.ErrConcurrentlyModified, want: StatusConcurrentlyModified},
+		{name: "transaction cancelled", overwrite: OverwriteNever, value: "Legal", writeErr: tovendor.ErrNotWritten, want: StatusNotWritten},
+		{name: "write not journaled", overwrite: OverwriteNever, value: "Legal", writeErr: tovendor.ErrNotJournaled, want: StatusNotJournaled},
+		{name: "write failure", overwrite: OverwriteNever, value: "Legal", writeErr: errors.New("throttled"), want: StatusFailedWrite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &Spec{
+				Name:      "legal_name",
+				Source:    SpecSource{JSONPath: "legal_name"},
+				Target:    SpecTarget{Attribute: "legal_name"},
+				SkipIf:    []SkipCondition{{Attribute: "name", Is: conditionEmpty}},
+				Overwrite: tt.overwrite,
+			}
+			repo := &fakeRepository{err: tt.writeErr}
+			src := fakeSource{
+				values: map[string]string{"v001": tt.value},
+				errs:   map[string]error{"v001": tt.lookupErr},
+			}
+			p := NewSpecPatcher(spec, repo, nil, src)
+
+			name := "Vendor One"
+			if tt.noName {
+				name = ""
+			}
+			vendor := tovendor.Vendor{Code: "v001", Attributes: map[string]string{"name": name, "legal_name": tt.current}}
+
+			result := p.Patch(context.Background(), vendor)
+			if result.Status != tt.want {
+				t.Fatalf("status = %s, want %s (err: %v)", result.Status, tt.want, result.Err)
+			}
+
+			isWritten := tt.want == StatusUpdated || tt.writeErr != nil
+			if !isWritten {
+				if len(repo.changes) != 0 {
+					t.Errorf("changes = %+v, want none", repo.changes)
+				}
+				return
+			}
+			if len(repo.changes) != 1 {
+				t.Fatalf("changes = %+v, want one", repo.changes)
+			}
+			change := repo.changes[0]
+			if change.Attribute != "legal_name" || change.Current != tt.current || change.Proposed != tt.value {
+				t.Errorf("change = %+v, want legal_name from %q to %q", change, tt.current, tt.value)
+			}
+		})
+	}
+}
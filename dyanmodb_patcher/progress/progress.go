@@ -0,0 +1,411 @@
+// Package progress reports how far the patch of every GEID of a run is, as bars on a terminal or as periodic log
+// lines otherwise.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome is what became of a processed vendor.
+type Outcome int
+
+const (
+	Updated Outcome = iota
+	Skipped
+	Failed
+	// Resumed is a vendor completed before the run was resumed, it's not counted in the rate.
+	Resumed
+)
+
+// rateWindow is the number of seconds the current rate is measured on.
+const rateWindow = 10
+
+// barWidth is the number of characters of a progress bar.
+const barWidth = 30
+
+// Tracker counts the vendors of a GEID, it's safe for concurrent use. A nil Tracker counts nothing.
+type Tracker struct {
+	name   string
+	logger *slog.Logger
+	now    func() time.Time
+	start  time.Time
+
+	mu sync.Mutex
+	// estimate is the expected number of vendors, discovered is the number of vendors queued so far. discovered is
+	// the total once isTotalKnown is set.
+	estimate     int
+	discovered   int
+	isTotalKnown bool
+	counts       [Resumed + 1]int
+	isFinished   bool
+	finishedAt   time.Time
+	// buckets count the vendors processed in each of the latest seconds, indexed by the second modulo rateWindow.
+	buckets [rateWindow]bucket
+}
+
+type bucket struct {
+	second int64
+	count  int
+}
+
+func newTracker(name string, logger *slog.Logger, now func() time.Time) *Tracker {
+	return &Tracker{name: name, logger: logger, now: now, start: now()}
+}
+
+// Estimate sets the expected number of vendors before all of them are queued, e.g. from a count of the table.
+func (t *Tracker) Estimate(total int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.estimate = total
+}
+
+// Discover counts n vendors queued to be processed.
+func (t *Tracker) Discover(n int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.discovered += n
+}
+
+// Complete tells that every vendor is queued, the discovered ones are then the total.
+func (t *Tracker) Complete() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.isTotalKnown = true
+}
+
+// Observe counts a processed vendor.
+func (t *Tracker) Observe(outcome Outcome) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[outcome]++
+	if outcome == Resumed {
+		return
+	}
+
+	second := t.now().Unix()
+	b := &t.buckets[second%rateWindow]
+	if b.second != second {
+		*b = bucket{second: second}
+	}
+	b.count++
+}
+
+// Finish tells that the patch of the GEID is over, whether all vendors are processed or not.
+func (t *Tracker) Finish() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.isFinished {
+		t.isFinished = true
+		t.finishedAt = t.now()
+	}
+}
+
+// Snapshot is the progress of a GEID at a point in time.
+type Snapshot struct {
+	Name      string
+	Processed int
+	// Total is an estimate until IsTotalKnown is set.
+	Total        int
+	IsTotalKnown bool
+	Updated      int
+	Skipped      int
+	Failed       int
+	Resumed      int
+	// Rate is the number of vendors processed per second over the latest seconds.
+	Rate float64
+	// ETA is 0 when it's unknown.
+	ETA        time.Duration
+	Elapsed    time.Duration
+	IsFinished bool
+}
+
+// Snapshot returns the current progress, it's empty for a nil Tracker.
+func (t *Tracker) Snapshot() Snapshot {
+	if t == nil {
+		return Snapshot{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if t.isFinished {
+		now = t.finishedAt
+	}
+
+	s := Snapshot{
+		Name:         t.name,
+		IsTotalKnown: t.isTotalKnown,
+		Updated:      t.counts[Updated],
+		Skipped:      t.counts[Skipped],
+		Failed:       t.counts[Failed],
+		Resumed:      t.counts[Resumed],
+		Elapsed:      now.Sub(t.start),
+		IsFinished:   t.isFinished,
+	}
+	s.Processed = s.Updated + s.Skipped + s.Failed + s.Resumed
+
+	s.Total = t.discovered
+	if !t.isTotalKnown {
+		s.Total = max(t.estimate, t.discovered, s.Processed)
+	}
+
+	s.Rate = t.rate(now, s.Processed-s.Resumed)
+	if remaining := s.Total - s.Processed; remaining > 0 && s.Rate > 0 && !t.isFinished {
+		s.ETA = time.Duration(float64(remaining) / s.Rate * float64(time.Second))
+	}
+	return s
+}
+
+// rate returns the vendors per second of the complete seconds in the window, or of the whole patch during its
+// first second. t.mu must be held.
+func (t *Tracker) rate(now time.Time, patched int) float64 {
+	second := now.Unix()
+	seconds := min(rateWindow-1, second-t.start.Unix())
+	if seconds <= 0 {
+		if elapsed := now.Sub(t.start).Seconds(); elapsed > 0 {
+			return float64(patched) / elapsed
+		}
+		return 0
+	}
+
+	count := 0
+	for _, b := range t.buckets {
+		if b.second >= second-seconds && b.second < second {
+			count += b.count
+		}
+	}
+	return float64(count) / float64(seconds)
+}
+
+// Reporter renders the progress of the trackers every interval until it's stopped. A nil Reporter tracks nothing.
+type Reporter struct {
+	out        io.Writer
+	isTerminal bool
+	interval   time.Duration
+	// output is held while bars are drawn so that they don't mix with the other output to the terminal, e.g. a
+	// prompt or the lines of Writer.
+	output sync.Locker
+	now    func() time.Time
+	// drawn is the number of bars drawn by the latest render, they are redrawn in place. bars are their lines.
+	// Both are guarded by output, drawn is reset once the reporter is stopped so that the last bars stay.
+	drawn int
+	bars  string
+
+	mu       sync.Mutex
+	trackers []*Tracker
+	// finished is the trackers whose patch is over, their progress isn't logged anymore.
+	finished map[*Tracker]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a reporter drawing bars to out when isTerminal is set, and logging the progress with the logger of
+// every tracker otherwise.
+func New(out io.Writer, isTerminal bool, interval time.Duration, output sync.Locker) *Reporter {
+	return &Reporter{
+		out:        out,
+		isTerminal: isTerminal,
+		interval:   interval,
+		output:     output,
+		now:        time.Now,
+		finished:   map[*Tracker]bool{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Track returns the tracker of the GEID name, its progress is logged with logger.
+func (r *Reporter) Track(name string, logger *slog.Logger) *Tracker {
+	if r == nil {
+		return nil
+	}
+
+	t := newTracker(name, logger, r.now)
+	r.mu.Lock()
+	r.trackers = append(r.trackers, t)
+	r.mu.Unlock()
+	return t
+}
+
+// Start renders the progress in the background.
+func (r *Reporter) Start() {
+	if r == nil {
+		return
+	}
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Render()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops rendering in the background and renders the final progress.
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	close(r.stop)
+	<-r.done
+	r.Render()
+
+	r.output.Lock()
+	r.drawn, r.bars = 0, ""
+	r.output.Unlock()
+}
+
+// Writer returns w with its writes serialized with the bars, e.g. for the log lines written to the terminal the bars
+// are drawn on. The bars are cleared before a write and drawn again below it. It's w for a nil Reporter.
+func (r *Reporter) Writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return &writer{r: r, w: w}
+}
+
+type writer struct {
+	r *Reporter
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.r.output.Lock()
+	defer w.r.output.Unlock()
+
+	if w.r.drawn == 0 {
+		return w.w.Write(p)
+	}
+
+	fmt.Fprintf(w.r.out, "\x1b[%dA\r\x1b[J", w.r.drawn)
+	n, err := w.w.Write(p)
+	io.WriteString(w.r.out, w.r.bars)
+	return n, err
+}
+
+// Render draws the bars of all trackers, or logs the progress of the ones in progress.
+func (r *Reporter) Render() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isTerminal {
+		r.draw()
+		return
+	}
+
+	for _, t := range r.trackers {
+		if r.finished[t] {
+			continue
+		}
+
+		s := t.Snapshot()
+		if s.IsFinished {
+			// the summary of the patch is logged when it finishes.
+			r.finished[t] = true
+			continue
+		}
+		if s.Total == 0 && s.Processed == 0 {
+			continue
+		}
+
+		t.logger.Info("Progress",
+			"processed", s.Processed,
+			"total", s.Total,
+			"is_total_known", s.IsTotalKnown,
+			"updated", s.Updated,
+			"skipped", s.Skipped+s.Resumed,
+			"failed", s.Failed,
+			"rate", fmt.Sprintf("%.1f/s", s.Rate),
+			"eta", s.ETA.Round(time.Second),
+		)
+	}
+}
+
+// draw redraws the bars in place of the ones of the previous render, r.mu must be held.
+func (r *Reporter) draw() {
+	if len(r.trackers) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	for _, t := range r.trackers {
+		b.WriteString("\r\x1b[2K")
+		b.WriteString(Bar(t.Snapshot()))
+		b.WriteString("\n")
+	}
+	bars := b.String()
+
+	r.output.Lock()
+	defer r.output.Unlock()
+	if r.drawn > 0 {
+		bars = fmt.Sprintf("\x1b[%dA", r.drawn) + bars
+	}
+	if _, err := io.WriteString(r.out, bars); err == nil {
+		r.drawn, r.bars = len(r.trackers), b.String()
+	}
+}
+
+// Bar returns the progress bar of s, e.g.
+// FP_SG [#########---------------------]  6000/20000  30%  updated 5800 skipped 150 failed 50  85.3/s  ETA 2m44s
+// The total is prefixed with ~ while it's an estimate.
+func Bar(s Snapshot) string {
+	ratio := 0.0
+	if s.Total > 0 {
+		ratio = min(float64(s.Processed)/float64(s.Total), 1)
+	}
+	filled := int(ratio * barWidth)
+
+	total := fmt.Sprint(s.Total)
+	if !s.IsTotalKnown {
+		total = "~" + total
+	}
+
+	line := fmt.Sprintf("%s [%s%s] %6v/%s %3.0f%%  updated %v skipped %v failed %v",
+		s.Name, strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled),
+		s.Processed, total, ratio*100, s.Updated, s.Skipped+s.Resumed, s.Failed)
+
+	if s.IsFinished {
+		return line + fmt.Sprintf("  done in %v", s.Elapsed.Round(time.Second))
+	}
+	line += fmt.Sprintf("  %.1f/s", s.Rate)
+	if s.ETA > 0 {
+		line += fmt.Sprintf("  ETA %v", s.ETA.Round(time.Second))
+	}
+	return line
+}
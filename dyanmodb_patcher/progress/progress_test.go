@@ -0,0 +1,169 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a fake time.Now which only moves when it's told to.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestReporter(out *bytes.Buffer, isTerminal bool) (*Reporter, *clock) {
+	c := &clock{now: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}
+	r := New(out, isTerminal, time.Hour, &sync.Mutex{})
+	r.now = c.Now
+	return r, c
+}
+
+func TestSnapshot(t *testing.T) {
+	r, c := newTestReporter(&bytes.Buffer{}, true)
+	tracker := r.Track("FP_SG", slog.Default())
+	tracker.Estimate(100)
+	tracker.Discover(10)
+	tracker.Observe(Resumed)
+
+	// 2 vendors per second for 5 seconds, the failure in the current second isn't counted in the rate yet.
+	for i := 0; i < 5; i++ {
+		tracker.Observe(Updated)
+		tracker.Observe(Skipped)
+		c.now = c.now.Add(time.Second)
+	}
+	tracker.Observe(Failed)
+
+	s := tracker.Snapshot()
+	if s.Processed != 12 || s.Updated != 5 || s.Skipped != 5 || s.Failed != 1 || s.Resumed != 1 {
+		t.Errorf("counts of %+v", s)
+	}
+	if s.Total != 100 || s.IsTotalKnown {
+		t.Errorf("total = %v, want the estimate of 100", s.Total)
+	}
+	if s.Rate != 2 {
+		t.Errorf("rate = %v, want 10 vendors in 5s", s.Rate)
+	}
+	if s.ETA != 44*time.Second {
+		t.Errorf("ETA = %v, want 88 vendors at 2/s", s.ETA)
+	}
+
+	tracker.Complete()
+	if s := tracker.Snapshot(); s.Total != 10 || !s.IsTotalKnown {
+		t.Errorf("total = %v, want the 10 discovered vendors once complete", s.Total)
+	}
+}
+
+func TestRateWindow(t *testing.T) {
+	r, c := newTestReporter(&bytes.Buffer{}, true)
+	tracker := r.Track("FP_SG", slog.Default())
+
+	// 10 vendors per second, then none for the whole window.
+	for i := 0; i < 20; i++ {
+		c.now = c.now.Add(100 * time.Millisecond)
+		tracker.Observe(Updated)
+	}
+	c.now = c.now.Add(rateWindow * time.Second)
+
+	if s := tracker.Snapshot(); s.Rate != 0 || s.ETA != 0 {
+		t.Errorf("rate = %v and ETA = %v after an idle window", s.Rate, s.ETA)
+	}
+}
+
+func TestRenderBars(t *testing.T) {
+	var out bytes.Buffer
+	r, c := newTestReporter(&out, true)
+	tracker := r.Track("FP_SG", slog.Default())
+	tracker.Discover(4)
+	tracker.Complete()
+	c.now = c.now.Add(2 * time.Second)
+	tracker.Observe(Updated)
+
+	r.Render()
+	if !strings.Contains(out.String(), "FP_SG [#######-----------------------]      1/4  25%  updated 1 skipped 0 failed 0") {
+		t.Errorf("bar is %q", out.String())
+	}
+
+	out.Reset()
+	tracker.Observe(Updated)
+	tracker.Finish()
+	r.Render()
+	if !strings.HasPrefix(out.String(), "\x1b[1A") || !strings.Contains(out.String(), "2/4  50%  updated 2 skipped 0 failed 0  done in 2s") {
+		t.Errorf("bar is not redrawn in place: %q", out.String())
+	}
+}
+
+func TestWriterKeepsBars(t *testing.T) {
+	var out bytes.Buffer
+	r, _ := newTestReporter(&out, true)
+	tracker := r.Track("FP_SG", slog.Default())
+	tracker.Discover(4)
+	r.Render()
+	bar := strings.TrimPrefix(out.String(), "\r\x1b[2K")
+
+	out.Reset()
+	w := r.Writer(&out)
+	if _, err := w.Write([]byte("level=WARN msg=\"Patched vendor\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x1b[1A\r\x1b[J" + "level=WARN msg=\"Patched vendor\"\n" + "\r\x1b[2K" + bar; out.String() != want {
+		t.Errorf("log line is written as %q, want %q", out.String(), want)
+	}
+
+	// the bars of a stopped reporter stay above the next lines.
+	r.Start()
+	r.Stop()
+	out.Reset()
+	w.Write([]byte("level=INFO msg=\"Run is completed\"\n"))
+	if out.String() != "level=INFO msg=\"Run is completed\"\n" {
+		t.Errorf("log line after stop is written as %q", out.String())
+	}
+}
+
+func TestRenderLogs(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	r, c := newTestReporter(&bytes.Buffer{}, false)
+
+	tracker := r.Track("FP_SG", logger.With("geid", "FP_SG"))
+	idle := r.Track("FP_TW", logger.With("geid", "FP_TW"))
+	tracker.Estimate(10)
+	tracker.Observe(Updated)
+	c.now = c.now.Add(time.Second)
+
+	r.Render()
+	var line map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single progress line of FP_SG, got %s", logs.String())
+	}
+	if line["geid"] != "FP_SG" || line["processed"] != 1.0 || line["total"] != 10.0 || line["rate"] != "1.0/s" {
+		t.Errorf("progress line %s", logs.String())
+	}
+
+	logs.Reset()
+	tracker.Finish()
+	idle.Finish()
+	r.Render()
+	if logs.Len() != 0 {
+		t.Errorf("finished patches are logged: %s", logs.String())
+	}
+}
+
+func TestNilReporter(t *testing.T) {
+	var r *Reporter
+	tracker := r.Track("FP_SG", slog.Default())
+	tracker.Estimate(1)
+	tracker.Discover(1)
+	tracker.Observe(Updated)
+	tracker.Complete()
+	tracker.Finish()
+	r.Start()
+	r.Stop()
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter is a token bucket shared by all goroutines calling a downstream, a nil Limiter doesn't limit.
+type Limiter struct {
+	name     string
+	rps      float64
+	limiter  *rate.Limiter
+	requests atomic.Int64
+	waited   atomic.Int64
+}
+
+// Stats shows how a limiter throttled the run.
+type Stats struct {
+	Name     string        `json:"name"`
+	RPS      float64       `json:"rps"`
+	Requests int64         `json:"requests"`
+	Waited   time.Duration `json:"waited_ns"`
+}
+
+// New returns a limiter allowing rps requests per second, it returns nil when rps is not positive.
+func New(name string, rps float64) *Limiter {
+	if rps <= 0 {
+		return nil
+	}
+
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		name:    name,
+		rps:     rps,
+		limiter: rate.NewLimiter(rate.Limit(rps), burst),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	start := time.Now()
+	err := l.limiter.Wait(ctx)
+	l.requests.Add(1)
+	l.waited.Add(int64(time.Since(start)))
+	return err
+}
+
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		Name:     l.name,
+		RPS:      l.rps,
+		Requests: l.requests.Load(),
+		Waited:   time.Duration(l.waited.Load()),
+	}
+}
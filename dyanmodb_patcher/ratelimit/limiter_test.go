@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		rps       float64
+		wantNil   bool
+		wantBurst int
+	}{
+		{name: "unlimited", rps: 0, wantNil: true},
+		{name: "negative", rps: -1, wantNil: true},
+		{name: "below one", rps: 0.5, wantBurst: 1},
+		{name: "burst of rps", rps: 10, wantBurst: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New("test", tt.rps)
+			if tt.wantNil {
+				if l != nil {
+					t.Errorf("New(%v) = %+v, want nil", tt.rps, l)
+				}
+				return
+			}
+			if burst := l.limiter.Burst(); burst != tt.wantBurst {
+				t.Errorf("burst = %v, want %v", burst, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.Wait(ctx); err != nil {
+		t.Errorf("Wait of a nil limiter = %v, want nil", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := New("dynamodb_write", 20)
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// the burst of 20 is taken at once, the 2 requests after it wait for a token every 50ms.
+	if elapsed < 80*time.Millisecond {
+		t.Errorf("22 requests took %v, want them limited to 20 per second after the burst", elapsed)
+	}
+
+	stats := l.Stats()
+	if stats.Name != "dynamodb_write" || stats.RPS != 20 || stats.Requests != 22 {
+		t.Errorf("stats = %+v, want 22 requests of dynamodb_write at 20 rps", stats)
+	}
+	if stats.Waited < 80*time.Millisecond || stats.Waited > elapsed {
+		t.Errorf("waited %v, want the time the requests were held, at most %v", stats.Waited, elapsed)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	l := New("vendor_service", 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Errorf("Wait = %v, want an error as the next token is after the deadline", err)
+	}
+	if requests := l.Stats().Requests; requests != 2 {
+		t.Errorf("requests = %v, want the cancelled request counted", requests)
+	}
+}
@@ -0,0 +1,142 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Class is how a vendor attribute compares with its source of truth.
+type Class string
+
+const (
+	ClassInSync          Class = "in_sync"
+	ClassMissingInTable  Class = "missing_in_table"
+	ClassMissingInSource Class = "missing_in_source"
+	ClassMismatched      Class = "mismatched"
+	ClassSourceError     Class = "source_error"
+)
+
+// Classes lists all classes in the order they are reported.
+var Classes = []Class{
+	ClassInSync,
+	ClassMissingInTable,
+	ClassMissingInSource,
+	ClassMismatched,
+	ClassSourceError,
+}
+
+// Classify compares the value in the table with the one in the source.
+func Classify(tableValue, sourceValue string) Class {
+	switch {
+	case tableValue == sourceValue:
+		return ClassInSync
+	case tableValue == "":
+		return ClassMissingInTable
+	case sourceValue == "":
+		return ClassMissingInSource
+	}
+	return ClassMismatched
+}
+
+// Discrepancy is a vendor which is not in sync with the source.
+type Discrepancy struct {
+	VendorCode  string `json:"vendor_code"`
+	Class       Class  `json:"class"`
+	TableValue  string `json:"table_value"`
+	SourceValue string `json:"source_value"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Audit aggregates the verification of a GEID, it's safe for concurrent use.
+type Audit struct {
+	mu sync.Mutex
+
+	Env           string        `json:"env"`
+	GEID          string        `json:"geid"`
+	Target        string        `json:"target"`
+	Attribute     string        `json:"attribute"`
+	Source        string        `json:"source"`
+	Total         int           `json:"total"`
+	Counts        map[Class]int `json:"counts"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+func NewAudit(env, geid, target, attribute, source string) *Audit {
+	return &Audit{
+		Env:           env,
+		GEID:          geid,
+		Target:        target,
+		Attribute:     attribute,
+		Source:        source,
+		Counts:        make(map[Class]int),
+		Discrepancies: []Discrepancy{},
+	}
+}
+
+func (a *Audit) Add(discrepancy Discrepancy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.Total++
+	a.Counts[discrepancy.Class]++
+	if discrepancy.Class != ClassInSync {
+		a.Discrepancies = append(a.Discrepancies, discrepancy)
+	}
+}
+
+func (a *Audit) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	str := fmt.Sprintf("%v vendors in %s", a.Total, a.GEID)
+	for _, class := range Classes {
+		str += fmt.Sprintf(", %s: %v", class, a.Counts[class])
+	}
+	return str
+}
+
+// Write saves the audit as verify-<geid>.json and its discrepancies as verify-<geid>.csv under dir.
+func (a *Audit) Write(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sort.Slice(a.Discrepancies, func(i, j int) bool {
+		return a.Discrepancies[i].VendorCode < a.Discrepancies[j].VendorCode
+	})
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("verify-%s.json", a.GEID)), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write audit: %w", err)
+	}
+
+	file, err := os.Create(filepath.Join(dir, fmt.Sprintf("verify-%s.csv", a.GEID)))
+	if err != nil {
+		return fmt.Errorf("failed to create audit csv: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"vendor_code", "class", "table_value", "source_value", "error"})
+	for _, d := range a.Discrepancies {
+		w.Write([]string{d.VendorCode, string(d.Class), d.TableValue, d.SourceValue, d.Error})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write audit csv: %w", err)
+	}
+	return file.Close()
+}
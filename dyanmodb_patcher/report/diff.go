@@ -0,0 +1,67 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// DiffReport collects the changes proposed in a dry-run, it's safe for concurrent use.
+type DiffReport struct {
+	mu      sync.Mutex
+	changes []tovendor.Change
+}
+
+func NewDiffReport() *DiffReport {
+	return &DiffReport{}
+}
+
+func (r *DiffReport) Record(change tovendor.Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.changes = append(r.changes, change)
+}
+
+// Changes returns the recorded changes sorted by vendor code.
+func (r *DiffReport) Changes() []tovendor.Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := make([]tovendor.Change, len(r.changes))
+	copy(changes, r.changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].VendorCode < changes[j].VendorCode
+	})
+
+	return changes
+}
+
+func (r *DiffReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VENDOR_CODE\tATTRIBUTE\tCURRENT\tPROPOSED\tREASON")
+	for _, change := range r.Changes() {
+		fmt.Fprintf(tw, "%s\t%s\t%q\t%q\t%s\n", change.VendorCode, change.Attribute, change.Current, change.Proposed, change.Reason)
+	}
+	return tw.Flush()
+}
+
+func (r *DiffReport) WriteJSONFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r.Changes(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff report: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,252 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+)
+
+// VendorResult is the reported form of patcher.Result.
+type VendorResult struct {
+	VendorCode string         `json:"vendor_code"`
+	Status     patcher.Status `json:"status"`
+	Current    string         `json:"current"`
+	Proposed   string         `json:"proposed"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Summary aggregates the results of a patch run in a GEID, it's safe for concurrent use.
+type Summary struct {
+	mu sync.Mutex
+
+	RunID       string                 `json:"run_id"`
+	Env         string                 `json:"env"`
+	GEID        string                 `json:"geid"`
+	Target      string                 `json:"target"`
+	DryRun      bool                   `json:"dry_run"`
+	Interrupted bool                   `json:"interrupted"`
+	StartedAt   time.Time              `json:"started_at"`
+	FinishedAt  time.Time              `json:"finished_at"`
+	Total       int                    `json:"total"`
+	Counts      map[patcher.Status]int `json:"counts"`
+	// Resumed is the number of vendors completed by earlier attempts and skipped by the latest one.
+	Resumed int `json:"resumed"`
+	// RateLimits are the limiters shared by the whole run, so they include the requests of other GEIDs.
+	RateLimits []ratelimit.Stats `json:"rate_limits"`
+	// UnknownSourceCodes are the vendors listed in the source file but not found in the table.
+	UnknownSourceCodes []string       `json:"unknown_source_codes,omitempty"`
+	Canary             *Canary        `json:"canary,omitempty"`
+	Results            []VendorResult `json:"results"`
+	// Aborted is why the patch stopped before all vendors were scheduled, e.g. an exceeded error budget.
+	Aborted string `json:"aborted,omitempty"`
+}
+
+func NewSummary(runID, env, geid, target string, dryRun bool) *Summary {
+	return &Summary{
+		RunID:     runID,
+		Env:       env,
+		GEID:      geid,
+		Target:    target,
+		DryRun:    dryRun,
+		StartedAt: time.Now().UTC(),
+		Counts:    make(map[patcher.Status]int),
+		Results:   []VendorResult{},
+	}
+}
+
+func (s *Summary) SetUnknownSourceCodes(codes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.UnknownSourceCodes = codes
+}
+
+func (s *Summary) SetAborted(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Aborted = reason
+}
+
+func (s *Summary) SetCanary(canary *Canary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Canary = canary
+}
+
+func (s *Summary) SetResumed(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Resumed = n
+}
+
+// MergePrevious adds the results of the summary an earlier attempt of the run wrote under dir, so that a resumed
+// run reports all of its vendors. A vendor patched again keeps its latest result.
+func (s *Summary) MergePrevious(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("summary-%s.json", s.GEID)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read previous summary: %w", err)
+	}
+
+	var previous Summary
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return fmt.Errorf("malformed previous summary: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest := make(map[string]bool, len(s.Results))
+	for _, result := range s.Results {
+		latest[result.VendorCode] = true
+	}
+	for _, result := range previous.Results {
+		if latest[result.VendorCode] {
+			continue
+		}
+		s.Total++
+		s.Counts[result.Status]++
+		s.Results = append(s.Results, result)
+	}
+
+	s.StartedAt = previous.StartedAt
+	if s.Canary == nil {
+		s.Canary = previous.Canary
+	}
+	return nil
+}
+
+func (s *Summary) Add(result patcher.Result) {
+	vendorResult := VendorResult{
+		VendorCode: result.VendorCode,
+		Status:     result.Status,
+		Current:    result.Current,
+		Proposed:   result.Proposed,
+	}
+	if result.Err != nil {
+		vendorResult.Error = result.Err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Total++
+	s.Counts[result.Status]++
+	s.Results = append(s.Results, vendorResult)
+}
+
+// Finish marks the end of the run and sorts the results by vendor code.
+// interrupted tells the run is stopped before all vendors are scheduled.
+func (s *Summary) Finish(rateLimits []ratelimit.Stats, interrupted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Interrupted = interrupted
+	s.FinishedAt = time.Now().UTC()
+	s.RateLimits = rateLimits
+	sort.Slice(s.Results, func(i, j int) bool {
+		return s.Results[i].VendorCode < s.Results[j].VendorCode
+	})
+}
+
+func (s *Summary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	str := fmt.Sprintf("%v vendors in %s", s.Total, s.GEID)
+	if s.Aborted != "" {
+		str += fmt.Sprintf(" (aborted: %s)", s.Aborted)
+	} else if s.Interrupted {
+		str += " (interrupted)"
+	}
+	for _, status := range patcher.Statuses {
+		str += fmt.Sprintf(", %s: %v", status, s.Counts[status])
+	}
+	if s.Resumed > 0 {
+		str += fmt.Sprintf(", completed before resume: %v", s.Resumed)
+	}
+	if len(s.UnknownSourceCodes) > 0 {
+		str += fmt.Sprintf(", unknown vendors in source: %v", len(s.UnknownSourceCodes))
+	}
+	if s.Canary != nil {
+		str += fmt.Sprintf(", canary of %v vendors had error rate %.1f%%", s.Canary.Size, s.Canary.ErrorRate)
+		if !s.Canary.Proceeded {
+			str += " and stopped the rollout"
+		}
+	}
+	for _, stats := range s.RateLimits {
+		str += fmt.Sprintf(", %s limited to %v rps: %v requests waited %v", stats.Name, stats.RPS, stats.Requests, stats.Waited)
+	}
+	return str
+}
+
+// Write saves the summary as summary-<geid>.json and summary-<geid>.csv under dir.
+func (s *Summary) Write(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("summary-%s.json", s.GEID)), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+
+	return s.writeCSV(filepath.Join(dir, fmt.Sprintf("summary-%s.csv", s.GEID)))
+}
+
+func (s *Summary) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create summary csv: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"vendor_code", "status", "current", "proposed", "error"})
+	for _, result := range s.Results {
+		w.Write([]string{result.VendorCode, string(result.Status), result.Current, result.Proposed, result.Error})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write summary csv: %w", err)
+	}
+	return file.Close()
+}
+
+// Canary is the verification of the vendors patched before the rest of a GEID.
+type Canary struct {
+	Size         int      `json:"size"`
+	Errors       int      `json:"errors"`
+	ErrorRate    float64  `json:"error_rate"`
+	MaxErrorRate float64  `json:"max_error_rate"`
+	Mismatches   []string `json:"mismatches"`
+	Proceeded    bool     `json:"proceeded"`
+}
+
+// IsHealthy reports whether the rest can proceed without a confirmation, a canary which verified no vendor is not.
+func (c *Canary) IsHealthy() bool {
+	return c.Size > 0 && c.ErrorRate <= c.MaxErrorRate
+}
@@ -0,0 +1,187 @@
+package tovendor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+)
+
+// auditSKPrefix is the prefix of the sort key of audit items, they share the partition of the vendors of their GEID.
+const auditSKPrefix = "AUDIT#"
+
+// auditTimeFormat has a fixed width so that audit items are sorted by time.
+const auditTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// AuditRecord tells who changed an attribute of a vendor, when and how. OldValue is nil when the attribute didn't
+// exist before the write, and NewValue is nil when the write removed it.
+type AuditRecord struct {
+	Operator   string    `json:"operator" dynamodbav:"operator"`
+	RunID      string    `json:"run_id" dynamodbav:"run_id"`
+	Env        string    `json:"env" dynamodbav:"env"`
+	Target     string    `json:"target" dynamodbav:"target"`
+	GEID       string    `json:"geid" dynamodbav:"geid"`
+	VendorCode string    `json:"vendor_code" dynamodbav:"vendor_code"`
+	Attribute  string    `json:"attribute" dynamodbav:"attribute"`
+	OldValue   *string   `json:"old_value" dynamodbav:"old_value"`
+	NewValue   *string   `json:"new_value" dynamodbav:"new_value"`
+	Time       time.Time `json:"time" dynamodbav:"time"`
+}
+
+// AuditMetadata is what the repository doesn't know about its writes.
+type AuditMetadata struct {
+	Operator string
+	RunID    string
+	Env      string
+	Target   string
+}
+
+// AuditSink stores the audit records of the writes of the repository.
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+}
+
+// WithAudit makes the repository write an audit record to sink for every write, the records carry meta.
+func WithAudit(sink AuditSink, meta AuditMetadata) Option {
+	return func(s *DDBRepository) {
+		s.audit = sink
+		s.auditMeta = meta
+	}
+}
+
+// writeAudit records a write of attribute of vendorCode from oldValue to newValue when the repository is audited.
+// The write is already done, so a record which can't be written is logged instead of failing it.
+func (s *DDBRepository) writeAudit(ctx context.Context, vendorCode, attribute string, oldValue, newValue *string) {
+	if s.audit == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Operator:   s.auditMeta.Operator,
+		RunID:      s.auditMeta.RunID,
+		Env:        s.auditMeta.Env,
+		Target:     s.auditMeta.Target,
+		GEID:       s.globalEntity.ID,
+		VendorCode: vendorCode,
+		Attribute:  attribute,
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		Time:       time.Now().UTC(),
+	}
+	if err := s.audit.Write(ctx, record); err != nil {
+		s.logger.Error("Wrote vendor but failed to audit it", "vendor_code", vendorCode, "attribute", attribute,
+			"old_value", auditValue(oldValue), "new_value", auditValue(newValue), "audit_time", record.Time, "error", err)
+	}
+}
+
+// auditValue returns the value of an audit record to log, nil when it's absent.
+func auditValue(value *string) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+type auditClient interface {
+	QueryAll(ctx context.Context, in *dynamodb.QueryInput, out interface{}) error
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, out interface{}) error
+}
+
+// AuditTable stores audit records as items of the table of the vendors, in the partition of their GEID with a sort
+// key of AUDIT#<vendor code>#<time>#<run id>#<attribute>. Their sort key never matches the vendor query.
+type AuditTable struct {
+	client       auditClient
+	tableName    string
+	writeLimiter *ratelimit.Limiter
+}
+
+// NewAuditTable returns the audit sink of tableName, its writes share writeLimiter with the ones of the vendors.
+func NewAuditTable(client auditClient, tableName string, writeLimiter *ratelimit.Limiter) *AuditTable {
+	return &AuditTable{
+		client:       client,
+		tableName:    tableName,
+		writeLimiter: writeLimiter,
+	}
+}
+
+// Write puts record as a new item, an audit item is never overwritten.
+func (t *AuditTable) Write(ctx context.Context, record AuditRecord) error {
+	update := expression.Set(expression.Name("operator"), expression.Value(record.Operator)).
+		Set(expression.Name("run_id"), expression.Value(record.RunID)).
+		Set(expression.Name("env"), expression.Value(record.Env)).
+		Set(expression.Name("target"), expression.Value(record.Target)).
+		Set(expression.Name("geid"), expression.Value(record.GEID)).
+		Set(expression.Name("vendor_code"), expression.Value(record.VendorCode)).
+		Set(expression.Name("attribute"), expression.Value(record.Attribute)).
+		Set(expression.Name("time"), expression.Value(record.Time.UTC().Format(time.RFC3339Nano)))
+	// an absent value is left out of the item, it's read back as nil.
+	if record.OldValue != nil {
+		update = update.Set(expression.Name("old_value"), expression.Value(*record.OldValue))
+	}
+	if record.NewValue != nil {
+		update = update.Set(expression.Name("new_value"), expression.Value(*record.NewValue))
+	}
+
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.Name(sk).AttributeNotExists()).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	if err := t.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	sortKey := fmt.Sprintf("%s%s#%s#%s", auditVendorSKPrefix(record.VendorCode), record.Time.UTC().Format(auditTimeFormat), record.RunID, record.Attribute)
+	var out map[string]interface{}
+	err = t.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(t.tableName),
+		Key: map[string]types.AttributeValue{
+			pk: &types.AttributeValueMemberS{Value: vendorPK(record.GEID)},
+			sk: &types.AttributeValueMemberS{Value: sortKey},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	}, &out)
+	if err != nil {
+		return fmt.Errorf("failed to write audit item: %w", err)
+	}
+	return nil
+}
+
+// History returns the audit records of vendorCode in geid, oldest first.
+func (t *AuditTable) History(ctx context.Context, geid, vendorCode string) ([]AuditRecord, error) {
+	keyEx := expression.Key(pk).Equal(expression.Value(vendorPK(geid))).
+		And(expression.Key(sk).BeginsWith(auditVendorSKPrefix(vendorCode)))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyEx).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []AuditRecord
+	err = t.client.QueryAll(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(t.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, &records)
+	if err != nil {
+		return nil, fmt.Errorf("fail to query audit items: %w", err)
+	}
+
+	return records, nil
+}
+
+func auditVendorSKPrefix(vendorCode string) string {
+	return fmt.Sprintf("%s%s#", auditSKPrefix, vendorCode)
+}
@@ -0,0 +1,361 @@
+package tovendor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaxBatchSize is the most items a group of batched writes holds.
+const MaxBatchSize = 25
+
+// BatchConfig configures the batched writes of the repository.
+type BatchConfig struct {
+	// Size is the most writes in a group, it's capped to MaxBatchSize.
+	Size int
+	// Linger is how long a group waits for concurrent writes before it's written.
+	Linger time.Duration
+}
+
+// DefaultBatchConfig groups the writes of MaxBatchSize concurrent vendors.
+var DefaultBatchConfig = BatchConfig{
+	Size:   MaxBatchSize,
+	Linger: 100 * time.Millisecond,
+}
+
+// Restore is a RestoreAttribute of RestoreAttributes.
+type Restore struct {
+	VendorCode string
+	Attribute  string
+	Written    string
+	Previous   *string
+}
+
+// write is an attribute update of a group, it's applied only when the attribute still has expected.
+type write struct {
+	vendorCode string
+	attribute  string
+	expected   string
+	// value is nil to remove the attribute.
+	value *string
+	// update is the conditional update of the write.
+	update *dynamodb.UpdateItemInput
+	done   chan writeResult
+}
+
+type writeResult struct {
+	// previous is the value of the attribute before the write, nil when it didn't exist.
+	previous *string
+	err      error
+}
+
+// batcher collects the writes of concurrent callers into groups, a group is written once it's full or its
+// Linger elapses.
+type batcher struct {
+	repo    *DDBRepository
+	cfg     BatchConfig
+	mu      sync.Mutex
+	ctx     context.Context
+	pending []*write
+	timer   *time.Timer
+}
+
+// WithBatchWrites groups the writes of UpdateAttribute made by concurrent callers and writes every group with
+// TransactWriteItems, every caller still gets the outcome of its own write.
+func WithBatchWrites(cfg BatchConfig) Option {
+	if cfg.Size <= 0 || cfg.Size > MaxBatchSize {
+		cfg.Size = MaxBatchSize
+	}
+
+	return func(s *DDBRepository) {
+		s.batcher = &batcher{
+			repo: s,
+			cfg:  cfg,
+		}
+	}
+}
+
+// write queues w and waits for the group it's written in. The group is written with the ctx of its first write
+// and w is always waited for, so that a write is never left unjournaled.
+func (b *batcher) write(ctx context.Context, w *write) writeResult {
+	w.done = make(chan writeResult, 1)
+
+	b.mu.Lock()
+	if len(b.pending) == 0 {
+		b.ctx = ctx
+		b.timer = time.AfterFunc(b.cfg.Linger, b.flushPending)
+	}
+	b.pending = append(b.pending, w)
+
+	var group []*write
+	var groupCtx context.Context
+	if len(b.pending) >= b.cfg.Size {
+		b.timer.Stop()
+		group, groupCtx = b.take()
+	}
+	b.mu.Unlock()
+
+	if group != nil {
+		b.repo.writeGroup(groupCtx, group)
+	}
+
+	return <-w.done
+}
+
+func (b *batcher) flushPending() {
+	b.mu.Lock()
+	group, ctx := b.take()
+	b.mu.Unlock()
+
+	if len(group) > 0 {
+		b.repo.writeGroup(ctx, group)
+	}
+}
+
+// take returns the pending group, b.mu must be held.
+func (b *batcher) take() ([]*write, context.Context) {
+	group, ctx := b.pending, b.ctx
+	b.pending, b.ctx = nil, nil
+	return group, ctx
+}
+
+// RestoreAttributes is the batched RestoreAttribute, restores are written in groups of the batch size in their
+// order. It returns the error of every restore, see RestoreAttribute.
+func (s *DDBRepository) RestoreAttributes(ctx context.Context, restores []Restore) []error {
+	size := MaxBatchSize
+	if s.batcher != nil {
+		size = s.batcher.cfg.Size
+	}
+
+	writes := make([]*write, len(restores))
+	errs := make([]error, len(restores))
+	for i, restore := range restores {
+		in, err := s.restoreAttributeInput(restore.VendorCode, restore.Attribute, restore.Written, restore.Previous)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		writes[i] = &write{
+			vendorCode: restore.VendorCode,
+			attribute:  restore.Attribute,
+			expected:   restore.Written,
+			value:      restore.Previous,
+			update:     in,
+			done:       make(chan writeResult, 1),
+		}
+	}
+
+	var group []*write
+	for i := 0; i <= len(writes); i++ {
+		if len(group) == size || (i == len(writes) && len(group) > 0) {
+			s.writeGroup(ctx, group)
+			group = nil
+		}
+		if i < len(writes) && writes[i] != nil {
+			group = append(group, writes[i])
+		}
+	}
+
+	for i, w := range writes {
+		if w == nil {
+			continue
+		}
+		errs[i] = (<-w.done).err
+		if errs[i] == nil {
+			restore := restores[i]
+			s.writeAudit(ctx, restore.VendorCode, restore.Attribute, &restore.Written, restore.Previous)
+		}
+	}
+	return errs
+}
+
+// writeGroup writes a group and sends the outcome of every write to it. A vendor can be written once per request,
+// so a write of a vendor already in the group is deferred to the next request.
+func (s *DDBRepository) writeGroup(ctx context.Context, group []*write) {
+	for len(group) > 0 {
+		var unique, deferred []*write
+		seen := map[string]bool{}
+		for _, w := range group {
+			if seen[w.vendorCode] {
+				deferred = append(deferred, w)
+				continue
+			}
+			seen[w.vendorCode] = true
+			unique = append(unique, w)
+		}
+
+		s.writeUnique(ctx, unique)
+		group = deferred
+	}
+}
+
+// writeUnique writes a group of distinct vendors. The items are read first, so that a changed item fails alone with
+// ErrConcurrentlyModified and the previous values are known.
+func (s *DDBRepository) writeUnique(ctx context.Context, group []*write) {
+	for range group {
+		if err := s.writeLimiter.Wait(ctx); err != nil {
+			failAll(group, err)
+			return
+		}
+	}
+
+	items, err := s.batchGetItems(ctx, group)
+	if err != nil {
+		failAll(group, err)
+		return
+	}
+
+	var ready []*write
+	var previous []*string
+	for _, w := range group {
+		item, ok := items[vendorSK(s.globalEntity.ID, w.vendorCode)]
+		if !ok {
+			w.done <- writeResult{err: fmt.Errorf("%w: vendor %s no longer exists", ErrConcurrentlyModified, w.vendorCode)}
+			continue
+		}
+
+		current, ok := hasValueIn(item, w.attribute, w.expected)
+		if !ok {
+			w.done <- writeResult{err: fmt.Errorf("%w: %s of vendor %s is changed", ErrConcurrentlyModified, w.attribute, w.vendorCode)}
+			continue
+		}
+
+		ready = append(ready, w)
+		previous = append(previous, current)
+	}
+
+	if len(ready) == 0 {
+		return
+	}
+
+	errs := s.transactWrites(ctx, ready)
+	for i, w := range ready {
+		w.done <- writeResult{previous: previous[i], err: errs[i]}
+	}
+}
+
+// batchGetItems reads the full items of the group keyed by their sort key.
+func (s *DDBRepository) batchGetItems(ctx context.Context, group []*write) (map[string]map[string]types.AttributeValue, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(group))
+	for _, w := range group {
+		keys = append(keys, s.vendorKey(w.vendorCode))
+	}
+
+	in := &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{
+			s.tableName: {
+				Keys:           keys,
+				ConsistentRead: aws.Bool(true),
+			},
+		},
+	}
+
+	found, err := s.ddbClient.BatchGetItem(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read vendors of the batch: %w", err)
+	}
+
+	items := make(map[string]map[string]types.AttributeValue, len(found))
+	for _, item := range found {
+		if key, ok := item[sk].(*types.AttributeValueMemberS); ok {
+			items[key.Value] = item
+		}
+	}
+	return items, nil
+}
+
+// transactWrites updates the items of the group in a transaction with the conditions of updateItem. A failed
+// condition is left out and the others are sent again, any other cancellation fails the writes with ErrNotWritten.
+func (s *DDBRepository) transactWrites(ctx context.Context, group []*write) []error {
+	errs := make([]error, len(group))
+	pending := make([]int, len(group))
+	for i := range group {
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		items := make([]types.TransactWriteItem, 0, len(pending))
+		for _, i := range pending {
+			w := group[i]
+			items = append(items, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName:                 w.update.TableName,
+					Key:                       w.update.Key,
+					UpdateExpression:          w.update.UpdateExpression,
+					ConditionExpression:       w.update.ConditionExpression,
+					ExpressionAttributeNames:  w.update.ExpressionAttributeNames,
+					ExpressionAttributeValues: w.update.ExpressionAttributeValues,
+				},
+			})
+		}
+
+		err := s.ddbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if err == nil {
+			return errs
+		}
+
+		var canceledErr *types.TransactionCanceledException
+		if !errors.As(err, &canceledErr) || len(canceledErr.CancellationReasons) != len(pending) {
+			for _, i := range pending {
+				errs[i] = fmt.Errorf("fail to write the transaction of %v vendors: %w", len(pending), err)
+			}
+			return errs
+		}
+
+		var retries []int
+		isConditionFailed := false
+		for j, i := range pending {
+			if aws.ToString(canceledErr.CancellationReasons[j].Code) == "ConditionalCheckFailed" {
+				errs[i] = fmt.Errorf("%w: %v", ErrConcurrentlyModified, err)
+				isConditionFailed = true
+				continue
+			}
+			retries = append(retries, i)
+		}
+
+		if !isConditionFailed {
+			for _, i := range retries {
+				errs[i] = fmt.Errorf("%w: %v", ErrNotWritten, err)
+			}
+			return errs
+		}
+
+		for range retries {
+			if err := s.writeLimiter.Wait(ctx); err != nil {
+				for _, i := range retries {
+					errs[i] = fmt.Errorf("%w: %v", ErrNotWritten, err)
+				}
+				return errs
+			}
+		}
+		pending = retries
+	}
+	return errs
+}
+
+func failAll(group []*write, err error) {
+	for _, w := range group {
+		w.done <- writeResult{err: err}
+	}
+}
+
+// hasValueIn is hasValue applied to a read item, it returns the current value of attribute, nil when it's absent.
+func hasValueIn(item map[string]types.AttributeValue, attribute, expected string) (*string, bool) {
+	value, ok := item[attribute]
+	if !ok {
+		return nil, expected == ""
+	}
+
+	str, ok := value.(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, false
+	}
+	return &str.Value, str.Value == expected
+}
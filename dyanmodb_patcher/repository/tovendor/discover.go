@@ -0,0 +1,76 @@
+package tovendor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+)
+
+const geidPKPrefix = "GEID#"
+
+type tableScanner interface {
+	ScanPage(ctx context.Context, in *dynamodb.ScanInput, out interface{}) (map[string]types.AttributeValue, error)
+}
+
+// DiscoverGEIDs returns the sorted GEIDs having a vendor in the table. It scans the whole table, limiter paces its
+// pages.
+func DiscoverGEIDs(ctx context.Context, client tableScanner, tableName string, limiter *ratelimit.Limiter) ([]string, error) {
+	filter := expression.And(
+		expression.Name(pk).BeginsWith(geidPKPrefix),
+		expression.Name(sk).Contains(",VENDOR#"),
+	)
+	expr, err := expression.NewBuilder().
+		WithFilter(filter).
+		WithProjection(expression.NamesList(expression.Name(pk), expression.Name(sk))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the expression of the GEID scan: %w", err)
+	}
+
+	in := &dynamodb.ScanInput{
+		TableName:                 aws.String(tableName),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	found := make(map[string]bool)
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		var keys []struct {
+			PK string `dynamodbav:"PK"`
+		}
+		lastEvaluatedKey, err := client.ScanPage(ctx, in, &keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the GEIDs of table %s: %w", tableName, err)
+		}
+
+		for _, key := range keys {
+			found[strings.TrimPrefix(key.PK, geidPKPrefix)] = true
+		}
+
+		if lastEvaluatedKey == nil {
+			break
+		}
+		in.ExclusiveStartKey = lastEvaluatedKey
+	}
+
+	geids := make([]string, 0, len(found))
+	for geid := range found {
+		geids = append(geids, geid)
+	}
+	sort.Strings(geids)
+	return geids, nil
+}
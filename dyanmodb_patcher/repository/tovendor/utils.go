@@ -1,7 +1,11 @@
 package tovendor
 
 import (
+	"encoding/json"
 	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 const (
@@ -9,6 +13,10 @@ const (
 	sk = "SK"
 )
 
+const (
+	AttrLocalLegalName = "local_legal_name"
+)
+
 func vendorPK(geid string) string {
 	return fmt.Sprintf("GEID#%s", geid)
 }
@@ -16,3 +24,49 @@ func vendorPK(geid string) string {
 func vendorSK(geid, vendorCode string) string {
 	return fmt.Sprintf("GEID#%s,VENDOR#%s", geid, vendorCode)
 }
+
+// hasValue asserts that the item still exists and attribute still has the value we read, empty when it's absent.
+// Every update should carry it so that we never clobber the writes of others.
+func hasValue(attribute, value string) expression.ConditionBuilder {
+	exists := expression.Name(sk).AttributeExists()
+	name := expression.Name(attribute)
+	if value == "" {
+		return exists.And(expression.Or(name.AttributeNotExists(), name.Equal(expression.Value(""))))
+	}
+	return exists.And(name.Equal(expression.Value(value)))
+}
+
+// encodePageKey serializes a LastEvaluatedKey of the vendor query, whose attributes are all strings.
+func encodePageKey(key map[string]types.AttributeValue) (string, error) {
+	if key == nil {
+		return "", nil
+	}
+
+	values := make(map[string]string, len(key))
+	for name, value := range key {
+		str, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("unsupported page key attribute %s of %T", name, value)
+		}
+		values[name] = str.Value
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page key: %w", err)
+	}
+	return string(data), nil
+}
+
+func decodePageKey(key string) (map[string]types.AttributeValue, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(key), &values); err != nil {
+		return nil, fmt.Errorf("invalid page key %s: %w", key, err)
+	}
+
+	decoded := make(map[string]types.AttributeValue, len(values))
+	for name, value := range values {
+		decoded[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	return decoded, nil
+}
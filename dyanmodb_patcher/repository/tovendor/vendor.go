@@ -2,42 +2,163 @@ package tovendor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
 	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
 )
 
+// ErrConcurrentlyModified is returned when the item no longer has the value we read, e.g. the dine-in worker
+// updated it after we queried it.
+var ErrConcurrentlyModified = errors.New("item is concurrently modified")
+
+// ErrNotJournaled is returned when the item is updated but the journal failed to keep it, so the update can't be
+// undone.
+var ErrNotJournaled = errors.New("item is updated but not journaled")
+
+// ErrNotWritten is returned when the transaction of a batched write is cancelled without its condition failing, so
+// the item is left as it was and the write can be retried.
+var ErrNotWritten = errors.New("item is not written")
+
 type DDBRepository struct {
 	ddbClient
-	env          utils.Env
 	globalEntity utils.GlobalEntity
 	tableName    string
+	dryRun       ChangeRecorder
+	journal      Journal
+	writeLimiter *ratelimit.Limiter
+	attributes   []string
+	batcher      *batcher
+	logger       *slog.Logger
+	audit        AuditSink
+	auditMeta    AuditMetadata
 }
 
 type Vendor struct {
 	Code           string `dynamodbav:"vendor_code"`
 	Name           string `dynamodbav:"name"`
 	LocalLegalName string `dynamodbav:"local_legal_name"`
+	// Attributes holds every string attribute of the item, including the ones projected by WithAttributes.
+	Attributes map[string]string `dynamodbav:"-"`
+}
+
+// Attribute returns the value of a string attribute, an absent attribute is returned as an empty string.
+func (v Vendor) Attribute(name string) string {
+	return v.Attributes[name]
+}
+
+func (v *Vendor) UnmarshalDynamoDBAttributeValue(av types.AttributeValue) error {
+	item, ok := av.(*types.AttributeValueMemberM)
+	if !ok {
+		return fmt.Errorf("vendor item is %T instead of a map", av)
+	}
+
+	// vendor has the fields of Vendor without its unmarshaler.
+	type vendor Vendor
+	var decoded vendor
+	if err := attributevalue.UnmarshalMap(item.Value, &decoded); err != nil {
+		return err
+	}
+
+	decoded.Attributes = make(map[string]string, len(item.Value))
+	for name, value := range item.Value {
+		if str, ok := value.(*types.AttributeValueMemberS); ok {
+			decoded.Attributes[name] = str.Value
+		}
+	}
+
+	*v = Vendor(decoded)
+	return nil
+}
+
+// Change describes a single attribute update on a vendor item.
+type Change struct {
+	VendorCode string `json:"vendor_code"`
+	Attribute  string `json:"attribute"`
+	Current    string `json:"current"`
+	Proposed   string `json:"proposed"`
+	Reason     string `json:"reason"`
+}
+
+// ChangeRecorder collects the changes that would have been written in dry-run mode.
+type ChangeRecorder interface {
+	Record(change Change)
+}
+
+// Journal keeps the value of an attribute before the repository wrote it, previous is nil when it didn't exist.
+type Journal interface {
+	Append(geid string, change Change, previous *string) error
 }
 
 type ddbClient interface {
 	QueryAll(ctx context.Context, in *dynamodb.QueryInput, out interface{}) error
+	QueryPage(ctx context.Context, in *dynamodb.QueryInput, out interface{}) (map[string]types.AttributeValue, error)
+	QueryCount(ctx context.Context, in *dynamodb.QueryInput) (int, error)
 	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, out interface{}) error
+	GetItem(ctx context.Context, in *dynamodb.GetItemInput, out interface{}) error
+	BatchGetItem(ctx context.Context, in *dynamodb.BatchGetItemInput) ([]map[string]types.AttributeValue, error)
+	TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput) error
+}
+
+type Option func(*DDBRepository)
+
+// WithDryRun makes every update of the repository go to recorder instead of DynamoDB.
+func WithDryRun(recorder ChangeRecorder) Option {
+	return func(s *DDBRepository) {
+		s.dryRun = recorder
+	}
 }
 
-func NewDDBRepository(ge utils.GlobalEntity, cfg config.Config, client ddbClient) *DDBRepository {
-	return &DDBRepository{
+// WithJournal appends every update of the repository to journal so that it can be undone.
+func WithJournal(journal Journal) Option {
+	return func(s *DDBRepository) {
+		s.journal = journal
+	}
+}
+
+// WithWriteLimiter throttles the writes of the repository, the limiter is usually shared by all repositories.
+func WithWriteLimiter(limiter *ratelimit.Limiter) Option {
+	return func(s *DDBRepository) {
+		s.writeLimiter = limiter
+	}
+}
+
+// WithLogger makes the repository log its writes to logger instead of the default one.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *DDBRepository) {
+		s.logger = logger
+	}
+}
+
+// WithAttributes adds attributes to the projection of GetAllVendors, they are available in Vendor.Attributes.
+func WithAttributes(attributes ...string) Option {
+	return func(s *DDBRepository) {
+		s.attributes = append(s.attributes, attributes...)
+	}
+}
+
+func NewDDBRepository(ge utils.GlobalEntity, cfg config.Config, client ddbClient, opts ...Option) *DDBRepository {
+	repo := &DDBRepository{
 		ddbClient:    client,
 		globalEntity: ge,
 		tableName:    cfg.AWS.DynamoDBTableName,
+		logger:       slog.Default(),
 	}
+
+	for _, opt := range opts {
+		opt(repo)
+	}
+
+	return repo
 }
 
 func (s *DDBRepository) GetAllVendors(ctx context.Context) ([]Vendor, error) {
@@ -55,17 +176,134 @@ func (s *DDBRepository) GetAllVendors(ctx context.Context) ([]Vendor, error) {
 	return vendors, nil
 }
 
-func (s *DDBRepository) getAllVendorsQueryInput() (*dynamodb.QueryInput, error) {
-	keyEx := expression.Key(pk).Equal(expression.Value(vendorPK(s.globalEntity.ID)))
-	keyEx = keyEx.And(expression.Key(sk).BeginsWith(fmt.Sprintf("GEID#%s,VENDOR", s.globalEntity.ID)))
+// IterateVendors returns an iterator over the vendors of the GEID, starting after startKey which is the
+// LastEvaluatedKey of a previous iterator. An empty startKey starts from the first vendor.
+func (s *DDBRepository) IterateVendors(startKey string) (*VendorIterator, error) {
+	in, err := s.getAllVendorsQueryInput()
+	if err != nil {
+		return nil, err
+	}
 
-	projection := expression.NamesList(
-		expression.Name("vendor_code"),
-		expression.Name("name"),
-		expression.Name("local_legal_name"),
-	)
+	if startKey != "" {
+		in.ExclusiveStartKey, err = decodePageKey(startKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	expr, err := expression.NewBuilder().WithKeyCondition(keyEx).WithProjection(projection).Build()
+	return &VendorIterator{
+		ddbClient: s.ddbClient,
+		in:        in,
+	}, nil
+}
+
+// VendorIterator reads vendors page by page, so the caller doesn't hold the whole partition in memory.
+//
+//	for it.Next(ctx) {
+//		process(it.Vendors())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type VendorIterator struct {
+	ddbClient        ddbClient
+	in               *dynamodb.QueryInput
+	vendors          []Vendor
+	lastEvaluatedKey string
+	done             bool
+	err              error
+}
+
+// Next reads the next page, it returns false when there are no more pages or on an error.
+func (it *VendorIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+
+	var vendors []Vendor
+	lastEvaluatedKey, err := it.ddbClient.QueryPage(ctx, it.in, &vendors)
+	if err != nil {
+		it.err = fmt.Errorf("fail to query vendors: %w", err)
+		it.done = true
+		return false
+	}
+
+	it.lastEvaluatedKey, err = encodePageKey(lastEvaluatedKey)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+
+	it.vendors = vendors
+	it.in.ExclusiveStartKey = lastEvaluatedKey
+	it.done = lastEvaluatedKey == nil
+	return true
+}
+
+func (it *VendorIterator) Vendors() []Vendor {
+	return it.vendors
+}
+
+// LastEvaluatedKey returns the key to resume after the current page, it's empty for the last page.
+func (it *VendorIterator) LastEvaluatedKey() string {
+	return it.lastEvaluatedKey
+}
+
+func (it *VendorIterator) Err() error {
+	return it.err
+}
+
+// GetVendor reads the vendor with a strongly consistent read, e.g. to verify what was written.
+func (s *DDBRepository) GetVendor(ctx context.Context, vendorCode string) (Vendor, error) {
+	expr, err := expression.NewBuilder().WithProjection(s.vendorProjection()).Build()
+	if err != nil {
+		return Vendor{}, err
+	}
+
+	in := &dynamodb.GetItemInput{
+		TableName:                aws.String(s.tableName),
+		Key:                      s.vendorKey(vendorCode),
+		ConsistentRead:           aws.Bool(true),
+		ExpressionAttributeNames: expr.Names(),
+		ProjectionExpression:     expr.Projection(),
+	}
+
+	var vendor Vendor
+	if err := s.ddbClient.GetItem(ctx, in, &vendor); err != nil {
+		return Vendor{}, fmt.Errorf("fail to get vendor %s: %w", vendorCode, err)
+	}
+
+	return vendor, nil
+}
+
+// CountVendors returns the number of vendors of the GEID, it reads the whole partition without returning it.
+func (s *DDBRepository) CountVendors(ctx context.Context) (int, error) {
+	expr, err := expression.NewBuilder().WithKeyCondition(s.vendorsKeyCondition()).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.ddbClient.QueryCount(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("fail to count vendors: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *DDBRepository) vendorsKeyCondition() expression.KeyConditionBuilder {
+	keyEx := expression.Key(pk).Equal(expression.Value(vendorPK(s.globalEntity.ID)))
+	return keyEx.And(expression.Key(sk).BeginsWith(fmt.Sprintf("GEID#%s,VENDOR", s.globalEntity.ID)))
+}
+
+func (s *DDBRepository) getAllVendorsQueryInput() (*dynamodb.QueryInput, error) {
+	expr, err := expression.NewBuilder().WithKeyCondition(s.vendorsKeyCondition()).WithProjection(s.vendorProjection()).Build()
 
 	if err != nil {
 		return nil, err
@@ -81,39 +319,161 @@ func (s *DDBRepository) getAllVendorsQueryInput() (*dynamodb.QueryInput, error)
 	}, nil
 }
 
-func (s *DDBRepository) UpdateLocalLegalName(ctx context.Context, vendorCode, localLegalName string) error {
-	in, err := s.updateLocalLegalNameInput(vendorCode, localLegalName)
+func (s *DDBRepository) vendorProjection() expression.ProjectionBuilder {
+	projected := map[string]bool{"vendor_code": true, "name": true, AttrLocalLegalName: true}
+	projection := expression.NamesList(
+		expression.Name("vendor_code"),
+		expression.Name("name"),
+		expression.Name(AttrLocalLegalName),
+	)
+	// DynamoDB rejects a projection with overlapping paths.
+	for _, attribute := range s.attributes {
+		if !projected[attribute] {
+			projected[attribute] = true
+			projection = projection.AddNames(expression.Name(attribute))
+		}
+	}
+
+	return projection
+}
+
+// UpdateAttribute is the only write path of the repository, every update method should go through it
+// so that dry-run applies to all targets.
+func (s *DDBRepository) UpdateAttribute(ctx context.Context, change Change) error {
+	if s.dryRun != nil {
+		s.dryRun.Record(change)
+		return nil
+	}
+
+	in, err := s.updateAttributeInput(change)
 	if err != nil {
 		return err
 	}
 
-	var vendor Vendor
+	var previous *string
+	if s.batcher != nil {
+		result := s.batcher.write(ctx, &write{
+			vendorCode: change.VendorCode,
+			attribute:  change.Attribute,
+			expected:   change.Current,
+			value:      &change.Proposed,
+			update:     in,
+		})
+		if result.err != nil {
+			return result.err
+		}
+		previous = result.previous
+	} else {
+		var oldItem map[string]interface{}
+
+		err = s.updateItem(ctx, in, &oldItem)
+		if err != nil {
+			return err
+		}
+
+		if value, ok := oldItem[change.Attribute].(string); ok {
+			previous = &value
+		}
+	}
 
-	err = s.ddbClient.UpdateItem(ctx, in, &vendor)
+	s.logger.Debug("Updated vendor", "vendor_code", change.VendorCode, "attribute", change.Attribute, "current", change.Current, "proposed", change.Proposed)
+
+	// the item is updated even when the journal fails, so it's audited first.
+	s.writeAudit(ctx, change.VendorCode, change.Attribute, previous, &change.Proposed)
+
+	if s.journal != nil {
+		if err := s.journal.Append(s.globalEntity.ID, change, previous); err != nil {
+			return fmt.Errorf("%w: vendor %s: %v", ErrNotJournaled, change.VendorCode, err)
+		}
+	}
+	return nil
+}
+
+// RestoreAttribute sets attribute back to previous, or removes it when previous is nil.
+// It returns ErrConcurrentlyModified when the attribute no longer has the written value.
+func (s *DDBRepository) RestoreAttribute(ctx context.Context, vendorCode, attribute, written string, previous *string) error {
+	in, err := s.restoreAttributeInput(vendorCode, attribute, written, previous)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Updated vendor attrs: %v\n", vendor)
+	var oldItem map[string]interface{}
+	if err := s.updateItem(ctx, in, &oldItem); err != nil {
+		return err
+	}
+
+	s.writeAudit(ctx, vendorCode, attribute, &written, previous)
 	return nil
 }
 
-func (s *DDBRepository) updateLocalLegalNameInput(vendorCode, localLegalName string) (*dynamodb.UpdateItemInput, error) {
-	update := expression.Set(expression.Name("local_legal_name"), expression.Value(localLegalName))
-	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+// updateItem sends the conditional update in under the write limit, see sendUpdate.
+func (s *DDBRepository) updateItem(ctx context.Context, in *dynamodb.UpdateItemInput, out interface{}) error {
+	if err := s.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	return s.sendUpdate(ctx, in, out)
+}
+
+// sendUpdate sends the conditional update in and maps the failed condition to ErrConcurrentlyModified.
+func (s *DDBRepository) sendUpdate(ctx context.Context, in *dynamodb.UpdateItemInput, out interface{}) error {
+	err := s.ddbClient.UpdateItem(ctx, in, out)
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("%w: %v", ErrConcurrentlyModified, err)
+	}
+
+	return err
+}
+
+func (s *DDBRepository) updateAttributeInput(change Change) (*dynamodb.UpdateItemInput, error) {
+	update := expression.Set(expression.Name(change.Attribute), expression.Value(change.Proposed))
+	condition := hasValue(change.Attribute, change.Current)
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
 	if err != nil {
 		return nil, err
 	}
 
 	return &dynamodb.UpdateItemInput{
-		TableName: aws.String(s.tableName),
-		Key: map[string]types.AttributeValue{
-			pk: &types.AttributeValueMemberS{Value: vendorPK(s.globalEntity.ID)},
-			sk: &types.AttributeValueMemberS{Value: vendorSK(s.globalEntity.ID, vendorCode)},
-		},
+		TableName:                 aws.String(s.tableName),
+		Key:                       s.vendorKey(change.VendorCode),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ReturnValues:              types.ReturnValueAllOld,
+	}, nil
+}
+
+func (s *DDBRepository) restoreAttributeInput(vendorCode, attribute, written string, previous *string) (*dynamodb.UpdateItemInput, error) {
+	var update expression.UpdateBuilder
+	if previous == nil {
+		update = expression.Remove(expression.Name(attribute))
+	} else {
+		update = expression.Set(expression.Name(attribute), expression.Value(*previous))
+	}
+
+	condition := hasValue(attribute, written)
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.tableName),
+		Key:                       s.vendorKey(vendorCode),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
-		ReturnValues:              types.ReturnValueUpdatedNew,
+		ConditionExpression:       expr.Condition(),
+		ReturnValues:              types.ReturnValueAllOld,
 	}, nil
 }
+
+func (s *DDBRepository) vendorKey(vendorCode string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		pk: &types.AttributeValueMemberS{Value: vendorPK(s.globalEntity.ID)},
+		sk: &types.AttributeValueMemberS{Value: vendorSK(s.globalEntity.ID, vendorCode)},
+	}
+}
@@ -0,0 +1,245 @@
+package tovendor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/config"
+	ddb "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/dynamodb/memory"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+const testTable = "vendors"
+
+func newTestRepository(t *testing.T, opts ...Option) (*DDBRepository, *memory.DB) {
+	t.Helper()
+
+	db := memory.New()
+	db.CreateTable(testTable)
+
+	ge, err := utils.NewGlobalEntity("FP_SG")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{AWS: config.AWS{DynamoDBTableName: testTable}}
+	return NewDDBRepository(ge, cfg, ddb.NewClientFrom(db), opts...), db
+}
+
+func putVendor(t *testing.T, db *memory.DB, code, localLegalName string) {
+	t.Helper()
+
+	item := map[string]types.AttributeValue{
+		pk:            &types.AttributeValueMemberS{Value: vendorPK("FP_SG")},
+		sk:            &types.AttributeValueMemberS{Value: vendorSK("FP_SG", code)},
+		"vendor_code": &types.AttributeValueMemberS{Value: code},
+	}
+	if localLegalName != "" {
+		item[AttrLocalLegalName] = &types.AttributeValueMemberS{Value: localLegalName}
+	}
+	if err := db.Put(testTable, item); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getItem(t *testing.T, db *memory.DB, code string) map[string]types.AttributeValue {
+	t.Helper()
+
+	out, err := db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(testTable),
+		Key: map[string]types.AttributeValue{
+			pk: &types.AttributeValueMemberS{Value: vendorPK("FP_SG")},
+			sk: &types.AttributeValueMemberS{Value: vendorSK("FP_SG", code)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Item
+}
+
+func TestUpdateAttributeConditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		exists  bool
+		current string
+		wantErr error
+	}{
+		{name: "absent attribute", exists: true},
+		{name: "unchanged value", exists: true, stored: "Old", current: "Old"},
+		{name: "attribute set since the read", exists: true, stored: "Worker", wantErr: ErrConcurrentlyModified},
+		{name: "value changed since the read", exists: true, stored: "Worker", current: "Old", wantErr: ErrConcurrentlyModified},
+		{name: "vendor deleted since the read", wantErr: ErrConcurrentlyModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, db := newTestRepository(t)
+			if tt.exists {
+				putVendor(t, db, "v001", tt.stored)
+			}
+
+			err := repo.UpdateAttribute(context.Background(), Change{
+				VendorCode: "v001",
+				Attribute:  AttrLocalLegalName,
+				Current:    tt.current,
+				Proposed:   "Legal One",
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			item := getItem(t, db, "v001")
+			if !tt.exists {
+				if item != nil {
+					t.Errorf("a deleted vendor is created again as %v", item)
+				}
+				return
+			}
+
+			want := tt.stored
+			if tt.wantErr == nil {
+				want = "Legal One"
+			}
+			if value, _ := item[AttrLocalLegalName].(*types.AttributeValueMemberS); value == nil || value.Value != want {
+				t.Errorf("local_legal_name = %v, want %q", item[AttrLocalLegalName], want)
+			}
+		})
+	}
+}
+
+// transactClient calls before ahead of every transaction, and cancels it with a conflict when isConflicting.
+type transactClient struct {
+	ddbClient
+	before        func()
+	isConflicting bool
+	calls         int
+}
+
+func (c *transactClient) TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput) error {
+	c.calls++
+	if c.before != nil {
+		c.before()
+	}
+	if !c.isConflicting {
+		return c.ddbClient.TransactWriteItems(ctx, in)
+	}
+
+	reasons := make([]types.CancellationReason, len(in.TransactItems))
+	for i := range reasons {
+		reasons[i].Code = aws.String("None")
+	}
+	reasons[0].Code = aws.String("TransactionConflict")
+	return &types.TransactionCanceledException{Message: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+}
+
+func TestRestoreAttributesCancelledTransaction(t *testing.T) {
+	tests := []struct {
+		name          string
+		isConflicting bool
+		workerWrites  bool
+		wantErrs      []error
+		wantCalls     int
+	}{
+		{
+			name:      "written",
+			wantErrs:  []error{nil, nil, nil},
+			wantCalls: 1,
+		},
+		{
+			name:         "failed condition is left out",
+			workerWrites: true,
+			wantErrs:     []error{nil, ErrConcurrentlyModified, nil},
+			wantCalls:    2,
+		},
+		{
+			name:          "conflict is not written",
+			isConflicting: true,
+			wantErrs:      []error{ErrNotWritten, ErrNotWritten, ErrNotWritten},
+			wantCalls:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, db := newTestRepository(t)
+			codes := []string{"v001", "v002", "v003"}
+			for _, code := range codes {
+				putVendor(t, db, code, "Legal")
+			}
+
+			client := &transactClient{ddbClient: repo.ddbClient, isConflicting: tt.isConflicting}
+			if tt.workerWrites {
+				// the dine-in worker changes v002 after it's read by the batch.
+				client.before = func() {
+					putVendor(t, db, "v002", "Worker")
+				}
+			}
+			repo.ddbClient = client
+
+			restores := make([]Restore, 0, len(codes))
+			for _, code := range codes {
+				restores = append(restores, Restore{VendorCode: code, Attribute: AttrLocalLegalName, Written: "Legal"})
+			}
+			errs := repo.RestoreAttributes(context.Background(), restores)
+
+			for i, code := range codes {
+				if !errors.Is(errs[i], tt.wantErrs[i]) || (tt.wantErrs[i] == nil) != (errs[i] == nil) {
+					t.Errorf("err of %s = %v, want %v", code, errs[i], tt.wantErrs[i])
+				}
+
+				_, isSet := getItem(t, db, code)[AttrLocalLegalName]
+				if isRestored := tt.wantErrs[i] == nil; isSet == isRestored {
+					t.Errorf("local_legal_name of %s is set %v, want it restored %v", code, isSet, isRestored)
+				}
+			}
+			if client.calls != tt.wantCalls {
+				t.Errorf("transactions = %v, want %v", client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDiscoverGEIDs(t *testing.T) {
+	db := memory.New()
+	db.CreateTable(testTable)
+	db.PageSize = 1
+
+	for _, key := range [][2]string{
+		{"GEID#FP_TW", "GEID#FP_TW,VENDOR#t001"},
+		{"GEID#FP_SG", "GEID#FP_SG,VENDOR#v001"},
+		{"GEID#FP_SG", "GEID#FP_SG,VENDOR#v002"},
+		{"GEID#FP_HK", "GEID#FP_HK,SETTINGS"},
+	} {
+		item := map[string]types.AttributeValue{
+			pk:            &types.AttributeValueMemberS{Value: key[0]},
+			sk:            &types.AttributeValueMemberS{Value: key[1]},
+			"vendor_code": &types.AttributeValueMemberS{Value: "v001"},
+		}
+		if err := db.Put(testTable, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limiter := ratelimit.New("dynamodb_read", 1000)
+	geids, err := DiscoverGEIDs(context.Background(), ddb.NewClientFrom(db), testTable, limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"FP_SG", "FP_TW"}; !reflect.DeepEqual(geids, want) {
+		t.Errorf("geids = %v, want %v", geids, want)
+	}
+	// a page per item and the empty page after the last one.
+	if requests := limiter.Stats().Requests; requests != 5 {
+		t.Errorf("limited scan pages = %v, want 5", requests)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/errorbudget"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/patcher"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+// stdoutMu serializes the terminal output of the GEIDs patched in parallel, e.g. log lines, dry-run tables and canary
+// prompts.
+var stdoutMu sync.Mutex
+
+// budget is the concurrency of n flag shared by the GEIDs of a run. A GEID holds up to its fair share of n divided
+// by the GEIDs in progress, so a large market can't starve the others, and the share grows as GEIDs finish.
+type budget struct {
+	mu     sync.Mutex
+	size   int
+	inUse  int
+	held   map[string]int
+	active int
+	// changed is closed and replaced whenever a slot is released or the share grows.
+	changed chan struct{}
+}
+
+func newBudget(size uint) *budget {
+	if size == 0 {
+		size = 1
+	}
+	return &budget{
+		size:    int(size),
+		held:    make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// join counts geid in the GEIDs sharing the budget.
+func (b *budget) join(geid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.active++
+	b.held[geid] = 0
+}
+
+// leave removes geid from the GEIDs sharing the budget, its share is given to the others.
+func (b *budget) leave(geid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.active--
+	delete(b.held, geid)
+	b.notify()
+}
+
+// acquire takes a slot for geid, it waits until a slot is free and geid holds less than its share.
+func (b *budget) acquire(ctx context.Context, geid string) error {
+	for {
+		b.mu.Lock()
+		if b.inUse < b.size && b.held[geid] < b.share() {
+			b.inUse++
+			b.held[geid]++
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *budget) release(geid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inUse--
+	b.held[geid]--
+	b.notify()
+}
+
+// share is the number of slots a GEID can hold, b.mu must be held.
+func (b *budget) share() int {
+	if b.active <= 1 {
+		return b.size
+	}
+	return (b.size + b.active - 1) / b.active
+}
+
+// notify wakes up the waiters of acquire, b.mu must be held.
+func (b *budget) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// patchAll patches the GEIDs concurrently and returns their errors in order. A failed GEID stops the others only on
+// a fatal error or a shared error budget.
+func patchAll(scheduleCtx, workCtx context.Context, r *run, globalEntities []utils.GlobalEntity) []error {
+	scheduleCtx, abortRun := context.WithCancelCause(scheduleCtx)
+	defer abortRun(nil)
+	r.abortRun = abortRun
+
+	if r.isRunErrorBudget {
+		var abort context.CancelCauseFunc
+		scheduleCtx, workCtx, abort = withAbort(scheduleCtx, workCtx)
+		defer abort(nil)
+		r.runErrors = &errorGuard{budget: errorbudget.New("run "+r.id, r.errorBudget), abort: abort}
+	}
+
+	errs := make([]error, len(globalEntities))
+	for _, globalEntity := range globalEntities {
+		r.budget.join(globalEntity.ID)
+	}
+
+	var wg sync.WaitGroup
+	for i, globalEntity := range globalEntities {
+		wg.Add(1)
+		go func(i int, globalEntity utils.GlobalEntity) {
+			defer wg.Done()
+			defer r.budget.leave(globalEntity.ID)
+
+			logger := r.geidLogger(globalEntity)
+			logger.Info("Start patching")
+			start := time.Now()
+			errs[i] = patch(scheduleCtx, workCtx, r, globalEntity)
+			if errs[i] != nil {
+				logger.Error("Failed to patch", "outcome", "failed", "duration", time.Since(start), "error", errs[i])
+			} else {
+				logger.Info("Patched", "outcome", "completed", "duration", time.Since(start))
+			}
+		}(i, globalEntity)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// errorGuard aborts the patch of a GEID, or of the whole run, once its error budget is exceeded.
+type errorGuard struct {
+	budget *errorbudget.Budget
+	abort  context.CancelCauseFunc
+}
+
+// record counts result in the budget, it aborts and returns the error of the budget once it's exceeded.
+func (g *errorGuard) record(result patcher.Result) error {
+	err := g.budget.Record(result.Status.IsFailed())
+	if err != nil {
+		g.abort(err)
+	}
+	return err
+}
+
+// runAbortedError is the cause of the run aborted on the fatal error of a GEID.
+type runAbortedError struct {
+	geid string
+	err  error
+}
+
+func (e *runAbortedError) Error() string {
+	return fmt.Sprintf("%s: %v", e.geid, e.err)
+}
+
+func (e *runAbortedError) Unwrap() error {
+	return e.err
+}
+
+// withAbort returns the contexts of scheduling and work derived from scheduleCtx and workCtx, and a function
+// cancelling both of them with a cause.
+func withAbort(scheduleCtx, workCtx context.Context) (context.Context, context.Context, context.CancelCauseFunc) {
+	scheduleCtx, cancelSchedule := context.WithCancelCause(scheduleCtx)
+	workCtx, cancelWork := context.WithCancelCause(workCtx)
+	return scheduleCtx, workCtx, func(cause error) {
+		cancelSchedule(cause)
+		cancelWork(cause)
+	}
+}
@@ -0,0 +1,282 @@
+package selection
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// Filter selects the vendors to patch among the ones returned by GetAllVendors.
+// The zero value selects all vendors.
+type Filter struct {
+	// Codes keeps only the listed vendors when it's not empty.
+	Codes []string
+	// Shard keeps the vendors whose code hashes to the shard.
+	Shard Shard
+	// Sample keeps a random sample of the size, it's reproducible with the same Seed.
+	Sample int
+	Seed   int64
+	// Limit keeps the first vendors ordered by code.
+	Limit int
+}
+
+// Apply returns the selected vendors ordered by code, and the codes of Codes that are not in vendors.
+// The filters are applied in the order of codes, shard, sample and limit.
+func (f Filter) Apply(vendors []tovendor.Vendor) ([]tovendor.Vendor, []string) {
+	selected := make([]tovendor.Vendor, 0, len(vendors))
+	var unknownCodes []string
+
+	if len(f.Codes) > 0 {
+		byCode := make(map[string]tovendor.Vendor, len(vendors))
+		for _, vendor := range vendors {
+			byCode[vendor.Code] = vendor
+		}
+
+		seen := make(map[string]bool, len(f.Codes))
+		for _, code := range f.Codes {
+			if seen[code] {
+				continue
+			}
+			seen[code] = true
+
+			vendor, ok := byCode[code]
+			if !ok {
+				unknownCodes = append(unknownCodes, code)
+				continue
+			}
+			selected = append(selected, vendor)
+		}
+	} else {
+		selected = append(selected, vendors...)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Code < selected[j].Code
+	})
+
+	if f.Shard.Count > 1 {
+		sharded := selected[:0]
+		for _, vendor := range selected {
+			if f.Shard.Contains(vendor.Code) {
+				sharded = append(sharded, vendor)
+			}
+		}
+		selected = sharded
+	}
+
+	if f.Sample > 0 && f.Sample < len(selected) {
+		rng := rand.New(rand.NewSource(f.Seed))
+		rng.Shuffle(len(selected), func(i, j int) {
+			selected[i], selected[j] = selected[j], selected[i]
+		})
+		selected = selected[:f.Sample]
+		sort.Slice(selected, func(i, j int) bool {
+			return selected[i].Code < selected[j].Code
+		})
+	}
+
+	if f.Limit > 0 && f.Limit < len(selected) {
+		selected = selected[:f.Limit]
+	}
+
+	return selected, unknownCodes
+}
+
+// Estimate returns about how many vendors the filter selects out of total vendors. Codes are assumed to be all
+// known and vendors to be spread evenly among the shards.
+func (f Filter) Estimate(total int) int {
+	if len(f.Codes) > 0 {
+		seen := make(map[string]bool, len(f.Codes))
+		for _, code := range f.Codes {
+			seen[code] = true
+		}
+		total = min(total, len(seen))
+	}
+	if f.Shard.Count > 1 {
+		total /= f.Shard.Count
+	}
+	if f.Sample > 0 {
+		total = min(total, f.Sample)
+	}
+	if f.Limit > 0 {
+		total = min(total, f.Limit)
+	}
+	return total
+}
+
+// IsStreamable tells whether the filter can select vendors page by page with a Selector, a sample needs all of
+// them at once.
+func (f Filter) IsStreamable() bool {
+	return f.Sample <= 0
+}
+
+// NewSelector returns a Selector of the filter for vendors read in the order of code. selected is the number of
+// vendors selected before the first page, it's counted against Limit.
+func (f Filter) NewSelector(selected int) *Selector {
+	codes := make(map[string]bool, len(f.Codes))
+	for _, code := range f.Codes {
+		codes[code] = true
+	}
+
+	return &Selector{
+		filter:   f,
+		codes:    codes,
+		known:    make(map[string]bool),
+		selected: selected,
+	}
+}
+
+// Selector applies a streamable Filter to pages of vendors ordered by code, it selects the same vendors as Apply.
+type Selector struct {
+	filter   Filter
+	codes    map[string]bool
+	known    map[string]bool
+	selected int
+}
+
+// Select returns the selected vendors of a page.
+func (s *Selector) Select(vendors []tovendor.Vendor) []tovendor.Vendor {
+	var selected []tovendor.Vendor
+	for _, vendor := range vendors {
+		if s.IsExhausted() {
+			break
+		}
+
+		if len(s.codes) > 0 {
+			if !s.codes[vendor.Code] {
+				continue
+			}
+			s.known[vendor.Code] = true
+		}
+
+		if !s.filter.Shard.Contains(vendor.Code) {
+			continue
+		}
+
+		selected = append(selected, vendor)
+		s.selected++
+	}
+	return selected
+}
+
+// Selected returns the number of vendors selected so far, including the ones before the first page.
+func (s *Selector) Selected() int {
+	return s.selected
+}
+
+// IsExhausted tells the limit is reached, so no more pages need to be read.
+func (s *Selector) IsExhausted() bool {
+	return s.filter.Limit > 0 && s.selected >= s.filter.Limit
+}
+
+// UnknownCodes returns the codes of Codes which are not in the pages selected so far.
+func (s *Selector) UnknownCodes() []string {
+	var unknownCodes []string
+	seen := make(map[string]bool, len(s.filter.Codes))
+	for _, code := range s.filter.Codes {
+		if seen[code] || s.known[code] {
+			continue
+		}
+		seen[code] = true
+		unknownCodes = append(unknownCodes, code)
+	}
+	return unknownCodes
+}
+
+// Shard is the Index-th of Count shards, Index starts from 0.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// Contains tells whether vendorCode belongs to the shard, it's stable across runs and machines.
+func (s Shard) Contains(vendorCode string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(vendorCode))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// ShardFlag parses a shard in the form of "i/n".
+type ShardFlag Shard
+
+func (s *ShardFlag) String() string {
+	if s.Count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+func (s *ShardFlag) Set(value string) error {
+	index, count, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("shard %s should be in the form of i/n", value)
+	}
+
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return fmt.Errorf("invalid shard index %s: %w", index, err)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return fmt.Errorf("invalid shard count %s: %w", count, err)
+	}
+
+	if n < 1 || i < 0 || i >= n {
+		return fmt.Errorf("shard %s is out of range, it should be 0 <= i < n", value)
+	}
+
+	*s = ShardFlag{Index: i, Count: n}
+	return nil
+}
+
+type VendorCodesFlag []string
+
+func (v *VendorCodesFlag) String() string {
+	return fmt.Sprint(*v)
+}
+
+func (v *VendorCodesFlag) Set(value string) error {
+	for _, code := range strings.Split(value, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			*v = append(*v, code)
+		}
+	}
+	return nil
+}
+
+// ReadVendorCodes reads a file with a vendor code per line, blank lines and lines starting with # are ignored.
+func ReadVendorCodes(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vendor file: %w", err)
+	}
+	defer file.Close()
+
+	var codes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		codes = append(codes, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vendor file: %w", err)
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,256 @@
+package selection
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+)
+
+// newVendors returns n vendors, in reverse order of code so that Apply has to sort them.
+func newVendors(n int) []tovendor.Vendor {
+	vendors := make([]tovendor.Vendor, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		vendors = append(vendors, tovendor.Vendor{Code: fmt.Sprintf("v%03d", i)})
+	}
+	return vendors
+}
+
+func codesOf(vendors []tovendor.Vendor) []string {
+	codes := []string{}
+	for _, vendor := range vendors {
+		codes = append(codes, vendor.Code)
+	}
+	return codes
+}
+
+func TestFilterApply(t *testing.T) {
+	vendors := newVendors(10)
+
+	tests := []struct {
+		name        string
+		filter      Filter
+		wantCodes   []string
+		wantUnknown []string
+	}{
+		{
+			name:      "all vendors",
+			wantCodes: []string{"v000", "v001", "v002", "v003", "v004", "v005", "v006", "v007", "v008", "v009"},
+		},
+		{
+			name:        "listed vendors",
+			filter:      Filter{Codes: []string{"v007", "v002", "x001", "v002"}},
+			wantCodes:   []string{"v002", "v007"},
+			wantUnknown: []string{"x001"},
+		},
+		{
+			name:      "limit",
+			filter:    Filter{Limit: 3},
+			wantCodes: []string{"v000", "v001", "v002"},
+		},
+		{
+			name:      "limit above the vendors",
+			filter:    Filter{Codes: []string{"v004", "v001"}, Limit: 5},
+			wantCodes: []string{"v001", "v004"},
+		},
+		{
+			name:      "limit of listed vendors",
+			filter:    Filter{Codes: []string{"v009", "v004", "v001"}, Limit: 2},
+			wantCodes: []string{"v001", "v004"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, unknown := tt.filter.Apply(vendors)
+			if codes := codesOf(selected); !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Errorf("selected = %v, want %v", codes, tt.wantCodes)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestFilterSample(t *testing.T) {
+	vendors := newVendors(100)
+	filter := Filter{Sample: 10, Seed: 42}
+
+	first, _ := filter.Apply(vendors)
+	second, _ := filter.Apply(newVendors(100))
+	if len(first) != 10 {
+		t.Fatalf("sampled %v vendors, want 10", len(first))
+	}
+	if !reflect.DeepEqual(codesOf(first), codesOf(second)) {
+		t.Errorf("samples of the same seed differ: %v and %v", codesOf(first), codesOf(second))
+	}
+
+	other, _ := Filter{Sample: 10, Seed: 7}.Apply(newVendors(100))
+	if reflect.DeepEqual(codesOf(first), codesOf(other)) {
+		t.Errorf("samples of seeds 42 and 7 are the same: %v", codesOf(first))
+	}
+
+	if filter.IsStreamable() {
+		t.Error("a sample filter is streamable")
+	}
+}
+
+// TestSelectorAgreesWithFilter selects the pages of vendors with a Selector and checks it selects what Apply does.
+func TestSelectorAgreesWithFilter(t *testing.T) {
+	filters := []Filter{
+		{},
+		{Codes: []string{"v030", "v001", "x999", "v017", "v001"}},
+		{Shard: Shard{Index: 1, Count: 3}},
+		{Limit: 7},
+		{Shard: Shard{Index: 0, Count: 2}, Limit: 5},
+		{Codes: []string{"v003", "v004", "v005", "v006", "v040"}, Shard: Shard{Index: 2, Count: 4}, Limit: 1},
+	}
+
+	vendors := newVendors(50)
+	ordered, _ := Filter{}.Apply(newVendors(50))
+
+	for _, filter := range filters {
+		for _, pageSize := range []int{1, 3, 50} {
+			t.Run(fmt.Sprintf("%+v by %v", filter, pageSize), func(t *testing.T) {
+				want, wantUnknown := filter.Apply(vendors)
+
+				selector := filter.NewSelector(0)
+				var got []tovendor.Vendor
+				for start := 0; start < len(ordered) && !selector.IsExhausted(); start += pageSize {
+					got = append(got, selector.Select(ordered[start:min(start+pageSize, len(ordered))])...)
+				}
+
+				if !reflect.DeepEqual(codesOf(got), codesOf(want)) {
+					t.Errorf("selector selected %v, filter %v", codesOf(got), codesOf(want))
+				}
+				if selector.Selected() != len(want) {
+					t.Errorf("selected count = %v, want %v", selector.Selected(), len(want))
+				}
+				// the pages after the limit are not read, so unknown codes are only known when it's not reached.
+				if unknown := selector.UnknownCodes(); !selector.IsExhausted() && !reflect.DeepEqual(unknown, wantUnknown) {
+					t.Errorf("selector unknown = %v, filter %v", unknown, wantUnknown)
+				}
+			})
+		}
+	}
+}
+
+func TestSelectorResumesLimit(t *testing.T) {
+	selector := Filter{Limit: 5}.NewSelector(3)
+	selected := selector.Select(newVendors(10))
+	if len(selected) != 2 || !selector.IsExhausted() {
+		t.Errorf("selected %v vendors after the 3 of earlier pages, want the 2 left in the limit", len(selected))
+	}
+}
+
+func TestShard(t *testing.T) {
+	const count = 4
+	vendors := newVendors(1000)
+
+	sizes := make([]int, count)
+	for _, vendor := range vendors {
+		var shards []int
+		for i := 0; i < count; i++ {
+			if (Shard{Index: i, Count: count}).Contains(vendor.Code) {
+				shards = append(shards, i)
+			}
+		}
+		if len(shards) != 1 {
+			t.Fatalf("%s is in shards %v, want exactly one", vendor.Code, shards)
+		}
+		sizes[shards[0]]++
+	}
+
+	// fnv spreads the codes about evenly, a shard far off a quarter means the hash is broken.
+	for i, size := range sizes {
+		if size < 150 || size > 350 {
+			t.Errorf("shard %v has %v of the 1000 vendors", i, size)
+		}
+	}
+
+	// the shard of a code must not change across releases, or a sharded run resumed after an upgrade skips vendors.
+	if !(Shard{Index: 3, Count: count}).Contains("v000") {
+		t.Error("v000 moved out of shard 3/4")
+	}
+	for _, shard := range []Shard{{}, {Index: 0, Count: 1}} {
+		if !shard.Contains("v000") {
+			t.Errorf("shard %+v doesn't contain every vendor", shard)
+		}
+	}
+}
+
+func TestShardFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ShardFlag
+		wantErr string
+	}{
+		{value: "0/4", want: ShardFlag{Index: 0, Count: 4}},
+		{value: "3/4", want: ShardFlag{Index: 3, Count: 4}},
+		{value: "0/1", want: ShardFlag{Index: 0, Count: 1}},
+		{value: "4/4", wantErr: "out of range"},
+		{value: "-1/4", wantErr: "out of range"},
+		{value: "0/0", wantErr: "out of range"},
+		{value: "1", wantErr: "in the form of i/n"},
+		{value: "a/4", wantErr: "invalid shard index"},
+		{value: "1/b", wantErr: "invalid shard count"},
+		{value: "", wantErr: "in the form of i/n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var flag ShardFlag
+			err := flag.Set(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Set(%q) = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flag != tt.want || flag.String() != tt.value {
+				t.Errorf("Set(%q) = %+v printed as %q", tt.value, flag, flag.String())
+			}
+		})
+	}
+}
+
+func TestVendorCodesFlag(t *testing.T) {
+	var flag VendorCodesFlag
+	for _, value := range []string{"a1b2, c3d4", ",e5f6,,"} {
+		if err := flag.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := (VendorCodesFlag{"a1b2", "c3d4", "e5f6"}); !reflect.DeepEqual(flag, want) {
+		t.Errorf("codes = %v, want %v", flag, want)
+	}
+}
+
+func TestReadVendorCodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vendors.txt")
+	content := "# vendors of the incident\nv001\n\n  v002  \n#v003\nv001\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := ReadVendorCodes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// duplicates are kept, Filter ignores them.
+	if want := []string{"v001", "v002", "v001"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("codes = %v, want %v", codes, want)
+	}
+
+	if _, err := ReadVendorCodes(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error for a missing vendor file")
+	}
+}
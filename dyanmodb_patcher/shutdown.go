@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// withShutdown returns scheduleCtx cancelled on the first SIGINT or SIGTERM, and workCtx cancelled gracePeriod later.
+// A second signal exits right away.
+func withShutdown(parent context.Context, gracePeriod time.Duration) (scheduleCtx, workCtx context.Context, stop func()) {
+	scheduleCtx, cancelSchedule := context.WithCancel(parent)
+	workCtx, cancelWork := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Received a signal, stop scheduling and wait for in-flight vendors. Send it again to force exit.", "signal", sig.String(), "grace_period", gracePeriod)
+			cancelSchedule()
+		case <-done:
+			return
+		}
+
+		timer := time.NewTimer(gracePeriod)
+		defer timer.Stop()
+
+		select {
+		case sig := <-signals:
+			slog.Error("Received a signal again, force exit", "signal", sig.String())
+			os.Exit(1)
+		case <-timer.C:
+			slog.Warn("Grace period is over, cancel in-flight vendors", "grace_period", gracePeriod)
+			cancelWork()
+		case <-done:
+		}
+	}()
+
+	stop = func() {
+		signal.Stop(signals)
+		close(done)
+		cancelSchedule()
+		cancelWork()
+	}
+	return scheduleCtx, workCtx, stop
+}
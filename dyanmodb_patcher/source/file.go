@@ -0,0 +1,155 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	columnVendorCode = "vendor_code"
+	columnValue      = "value"
+	columnGEID       = "geid"
+)
+
+// row is a line of a source file, GEID is optional and the row applies to every GEID when it's empty.
+type row struct {
+	VendorCode string `json:"vendor_code"`
+	Value      string `json:"value"`
+	GEID       string `json:"geid"`
+}
+
+// File serves the values of a CSV or JSONL file with vendor_code, value and an optional geid, e.g. manual
+// corrections handed over by product.
+type File struct {
+	path   string
+	codes  []string
+	values map[string]string
+}
+
+// LoadFile reads the rows of geid from a .csv or .jsonl file.
+func LoadFile(path, geid string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer file.Close()
+
+	var rows []row
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readCSV(file)
+	case ".jsonl":
+		rows, err = readJSONL(file)
+	default:
+		return nil, fmt.Errorf("unsupported source file %s, it should be .csv or .jsonl", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file %s: %w", path, err)
+	}
+
+	src := &File{
+		path:   path,
+		values: make(map[string]string),
+	}
+	for _, r := range rows {
+		if r.GEID != "" && r.GEID != geid {
+			continue
+		}
+		if r.VendorCode == "" {
+			return nil, fmt.Errorf("source file %s has a row without vendor_code", path)
+		}
+		if _, ok := src.values[r.VendorCode]; ok {
+			return nil, fmt.Errorf("source file %s has duplicated rows of vendor %s", path, r.VendorCode)
+		}
+
+		src.codes = append(src.codes, r.VendorCode)
+		src.values[r.VendorCode] = r.Value
+	}
+
+	return src, nil
+}
+
+func readCSV(r io.Reader) ([]row, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	codeIdx, hasCode := columns[columnVendorCode]
+	valueIdx, hasValue := columns[columnValue]
+	if !hasCode || !hasValue {
+		return nil, fmt.Errorf("header should have %s and %s columns", columnVendorCode, columnValue)
+	}
+	geidIdx, hasGEID := columns[columnGEID]
+
+	var rows []row
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r := row{
+			VendorCode: strings.TrimSpace(record[codeIdx]),
+			Value:      record[valueIdx],
+		}
+		if hasGEID {
+			r.GEID = strings.TrimSpace(record[geidIdx])
+		}
+		rows = append(rows, r)
+	}
+
+	return rows, nil
+}
+
+func readJSONL(r io.Reader) ([]row, error) {
+	var rows []row
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var r row
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			return nil, fmt.Errorf("malformed row at line %d: %w", lineNo, err)
+		}
+		rows = append(rows, r)
+	}
+
+	return rows, scanner.Err()
+}
+
+func (s *File) Lookup(ctx context.Context, vendorCode string) (string, error) {
+	return s.values[vendorCode], nil
+}
+
+func (s *File) ValidateEnvConfig() error {
+	return nil
+}
+
+func (s *File) String() string {
+	return fmt.Sprintf("file %s", filepath.Base(s.path))
+}
+
+func (s *File) VendorCodes() []string {
+	return s.codes
+}
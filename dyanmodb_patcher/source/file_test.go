@@ -0,0 +1,160 @@
+package source
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		wantCodes []string
+		want      map[string]string
+	}{
+		{
+			name:      "csv",
+			file:      "corrections.csv",
+			content:   "vendor_code,value\nv001,Legal One\n v002 ,\"Legal, Two\"\n",
+			wantCodes: []string{"v001", "v002"},
+			want:      map[string]string{"v001": "Legal One", "v002": "Legal, Two"},
+		},
+		{
+			name:      "csv with geid",
+			file:      "corrections.CSV",
+			content:   "geid,value,vendor_code\nFP_SG,Legal One,v001\nFP_TW,Legal Two,v002\n,Legal Three,v003\n",
+			wantCodes: []string{"v001", "v003"},
+			want:      map[string]string{"v001": "Legal One", "v002": "", "v003": "Legal Three"},
+		},
+		{
+			name:      "jsonl",
+			file:      "corrections.jsonl",
+			content:   "{\"vendor_code\":\"v001\",\"value\":\"Legal One\"}\n\n{\"vendor_code\":\"v002\",\"value\":\"Legal Two\",\"geid\":\"FP_TW\"}\n{\"vendor_code\":\"v003\",\"value\":\"\"}\n",
+			wantCodes: []string{"v001", "v003"},
+			want:      map[string]string{"v001": "Legal One", "v002": "", "v003": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := LoadFile(writeFile(t, tt.file, tt.content), "FP_SG")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if codes := src.VendorCodes(); !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", codes, tt.wantCodes)
+			}
+			for code, want := range tt.want {
+				value, err := src.Lookup(context.Background(), code)
+				if err != nil || value != want {
+					t.Errorf("Lookup(%s) = %q, %v, want %q", code, value, err, want)
+				}
+			}
+			if !strings.HasPrefix(src.String(), "file corrections.") {
+				t.Errorf("source is described as %q", src.String())
+			}
+		})
+	}
+}
+
+func TestLoadFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unsupported extension",
+			file:    "corrections.xlsx",
+			wantErr: "should be .csv or .jsonl",
+		},
+		{
+			name:    "csv without header",
+			file:    "corrections.csv",
+			wantErr: "failed to read header",
+		},
+		{
+			name:    "csv without value column",
+			file:    "corrections.csv",
+			content: "vendor_code,name\nv001,Legal One\n",
+			wantErr: "header should have vendor_code and value columns",
+		},
+		{
+			name:    "csv with a short row",
+			file:    "corrections.csv",
+			content: "vendor_code,value\nv001\n",
+			wantErr: "wrong number of fields",
+		},
+		{
+			name:    "malformed jsonl",
+			file:    "corrections.jsonl",
+			content: "{\"vendor_code\":\"v001\",\"value\":\"Legal One\"}\n{\"vendor_code\":\n",
+			wantErr: "malformed row at line 2",
+		},
+		{
+			name:    "row without vendor code",
+			file:    "corrections.csv",
+			content: "vendor_code,value\n,Legal One\n",
+			wantErr: "row without vendor_code",
+		},
+		{
+			name:    "duplicated vendor",
+			file:    "corrections.jsonl",
+			content: "{\"vendor_code\":\"v001\",\"value\":\"Legal One\"}\n{\"vendor_code\":\"v001\",\"value\":\"Legal Two\",\"geid\":\"FP_SG\"}\n",
+			wantErr: "duplicated rows of vendor v001",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writeFile(t, tt.file, tt.content), "FP_SG")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFileDuplicatedInOtherGEIDs(t *testing.T) {
+	path := writeFile(t, "corrections.csv", "vendor_code,value,geid\nv001,Legal One,FP_SG\nv001,Legal Two,FP_TW\n")
+
+	src, err := LoadFile(path, "FP_TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := src.Lookup(context.Background(), "v001"); value != "Legal Two" {
+		t.Errorf("value of v001 = %q, want the one of FP_TW", value)
+	}
+}
+
+func TestUnknownCodes(t *testing.T) {
+	src, err := LoadFile(writeFile(t, "corrections.csv", "vendor_code,value\nv003,c\nv001,a\nv002,b\n"), "FP_SG")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := UnknownCodes(src, map[string]bool{"v001": true})
+	if want := []string{"v003", "v002"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown codes = %v, want %v in the order of the file", unknown, want)
+	}
+	if unknown := UnknownCodes(src, map[string]bool{"v001": true, "v002": true, "v003": true}); len(unknown) != 0 {
+		t.Errorf("unknown codes = %v, want none", unknown)
+	}
+}
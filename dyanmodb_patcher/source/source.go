@@ -0,0 +1,31 @@
+package source
+
+import (
+	"context"
+)
+
+// Source provides the value a patcher writes for a vendor. Lookup returns an empty string when the source
+// has no value for the vendor.
+type Source interface {
+	Lookup(ctx context.Context, vendorCode string) (string, error)
+	ValidateEnvConfig() error
+	// String describes the source in change reasons, e.g. "vendor service account_name_localized".
+	String() string
+}
+
+// Enumerable is a Source that knows all the vendors it has a value for.
+type Enumerable interface {
+	Source
+	VendorCodes() []string
+}
+
+// UnknownCodes returns the codes of src which are not in knownCodes, sorted as they are in src.
+func UnknownCodes(src Enumerable, knownCodes map[string]bool) []string {
+	unknown := []string{}
+	for _, code := range src.VendorCodes() {
+		if !knownCodes[code] {
+			unknown = append(unknown, code)
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,33 @@
+package source
+
+import (
+	"context"
+	"fmt"
+
+	vendorSrv "github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/vendor_service"
+)
+
+// VendorService reads a field of the vendor from vendor service.
+type VendorService struct {
+	client   *vendorSrv.Client
+	jsonPath string
+}
+
+func NewVendorService(client *vendorSrv.Client, jsonPath string) *VendorService {
+	return &VendorService{
+		client:   client,
+		jsonPath: jsonPath,
+	}
+}
+
+func (s *VendorService) Lookup(ctx context.Context, vendorCode string) (string, error) {
+	return s.client.GetField(ctx, vendorCode, s.jsonPath)
+}
+
+func (s *VendorService) ValidateEnvConfig() error {
+	return s.client.ValidateEnvConfig()
+}
+
+func (s *VendorService) String() string {
+	return fmt.Sprintf("vendor service %s", s.jsonPath)
+}
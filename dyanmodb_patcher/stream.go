@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/checkpoint"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/progress"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/selection"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+// queuedVendor is a selected vendor and the index of the page it's read from.
+type queuedVendor struct {
+	vendor tovendor.Vendor
+	page   int
+}
+
+// vendorStream reads the vendors of a GEID a page ahead of the workers and yields the selected ones. next and hasNext
+// are meant to be called from a single goroutine.
+type vendorStream struct {
+	vendors chan queuedVendor
+	peeked  *queuedVendor
+	pages   *pageTracker
+	tracker *progress.Tracker
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// the fields below are set once done is closed.
+	err      error
+	selected int
+	// unknownCodes and unknownSourceCodes are only known when the whole query is read from the first page.
+	unknownCodes       []string
+	unknownSourceCodes []string
+}
+
+// streamVendors starts reading the selected vendors of wl, after the last page completed in the checkpoint if any.
+// A sample needs all vendors, so it's selected after the last page is read.
+func streamVendors(ctx context.Context, r *run, globalEntity utils.GlobalEntity, wl *workload, tracker *progress.Tracker) *vendorStream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &vendorStream{
+		vendors: make(chan queuedVendor),
+		tracker: tracker,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	logger := r.geidLogger(globalEntity)
+	var start checkpoint.Page
+	if r.checkpoint != nil {
+		s.pages = &pageTracker{
+			checkpoint: r.checkpoint,
+			geid:       globalEntity.ID,
+			logger:     logger,
+		}
+
+		if page, ok := r.checkpoint.Page(globalEntity.ID); ok && r.filter.IsStreamable() {
+			start = page
+			logger.Info("Resume vendors after the selected vendors of the checkpoint", "selected", start.Selected)
+		}
+	}
+
+	if tracker != nil && progressEstimateFlag {
+		go estimateVendors(ctx, logger, r.filter, wl, start, tracker)
+	}
+
+	go func() {
+		defer close(s.done)
+		defer close(s.vendors)
+
+		s.err = s.read(ctx, r.filter, wl, start)
+		if s.err == nil && ctx.Err() == nil {
+			tracker.Complete()
+			if len(s.unknownSourceCodes) > 0 {
+				logger.Warn("Vendors of source file are not found", "source", wl.source.String(), "vendor_codes", s.unknownSourceCodes)
+			}
+			if len(s.unknownCodes) > 0 {
+				logger.Warn("Selected vendors are not found", "vendor_codes", s.unknownCodes)
+			}
+			logger.Info("Selected vendors", "vendors", s.selected)
+		}
+	}()
+
+	return s
+}
+
+// estimateVendors estimates the number of vendors of wl the stream selects after start from a count of them, so
+// that the progress has a total before every page is read.
+func estimateVendors(ctx context.Context, logger *slog.Logger, filter selection.Filter, wl *workload, start checkpoint.Page, tracker *progress.Tracker) {
+	count, err := wl.vendorRepository.CountVendors(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("Failed to count vendors, the progress has no total until they are read", "error", err)
+		}
+		return
+	}
+
+	if wl.source != nil {
+		count = min(count, len(wl.source.VendorCodes()))
+	}
+	tracker.Estimate(filter.Estimate(count) - start.Selected)
+}
+
+func (s *vendorStream) read(ctx context.Context, filter selection.Filter, wl *workload, start checkpoint.Page) error {
+	it, err := wl.vendorRepository.IterateVendors(start.Key)
+	if err != nil {
+		return err
+	}
+
+	var listed map[string]bool
+	if wl.source != nil {
+		listed = map[string]bool{}
+		for _, code := range wl.source.VendorCodes() {
+			listed[code] = true
+		}
+	}
+
+	known := map[string]bool{}
+	selector := filter.NewSelector(start.Selected)
+	var all []tovendor.Vendor
+	isExhausted := false
+
+	for it.Next(ctx) {
+		var vendors []tovendor.Vendor
+		for _, vendor := range it.Vendors() {
+			known[vendor.Code] = true
+			if listed == nil || listed[vendor.Code] {
+				vendors = append(vendors, vendor)
+			}
+		}
+
+		if !filter.IsStreamable() {
+			all = append(all, vendors...)
+			continue
+		}
+
+		vendors = selector.Select(vendors)
+		page := s.pages.add(checkpoint.Page{Key: it.LastEvaluatedKey(), Selected: selector.Selected()}, len(vendors))
+		if !s.send(ctx, vendors, page) {
+			return nil
+		}
+
+		if selector.IsExhausted() {
+			isExhausted = true
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if !filter.IsStreamable() {
+		var vendors []tovendor.Vendor
+		vendors, s.unknownCodes = filter.Apply(all)
+		s.selected = len(vendors)
+		page := s.pages.add(checkpoint.Page{}, len(vendors))
+		s.send(ctx, vendors, page)
+		if wl.source != nil {
+			s.unknownSourceCodes = source.UnknownCodes(wl.source, known)
+		}
+		return nil
+	}
+
+	s.selected = selector.Selected()
+	if start.Key == "" && !isExhausted {
+		s.unknownCodes = selector.UnknownCodes()
+		if wl.source != nil {
+			s.unknownSourceCodes = source.UnknownCodes(wl.source, known)
+		}
+	}
+	return nil
+}
+
+// send queues the vendors of a page, it's false when ctx is done before all of them are taken.
+func (s *vendorStream) send(ctx context.Context, vendors []tovendor.Vendor, page int) bool {
+	for _, vendor := range vendors {
+		select {
+		case s.vendors <- queuedVendor{vendor: vendor, page: page}:
+			s.tracker.Discover(1)
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// next returns the next selected vendor, it's false when the stream is drained or ctx is done.
+func (s *vendorStream) next(ctx context.Context) (queuedVendor, bool) {
+	if s.peeked != nil {
+		queued := *s.peeked
+		s.peeked = nil
+		return queued, true
+	}
+
+	select {
+	case queued, ok := <-s.vendors:
+		return queued, ok
+	case <-ctx.Done():
+		return queuedVendor{}, false
+	}
+}
+
+// hasNext waits until the next vendor is read without taking it.
+func (s *vendorStream) hasNext(ctx context.Context) bool {
+	if s.peeked != nil {
+		return true
+	}
+
+	queued, ok := s.next(ctx)
+	if ok {
+		s.peeked = &queued
+	}
+	return ok
+}
+
+// stop stops reading vendors and waits for the reader to exit, the vendors not taken yet are dropped.
+// It returns the error of reading vendors.
+func (s *vendorStream) stop() error {
+	s.cancel()
+	<-s.done
+	return s.err
+}
+
+// pageTracker records to the checkpoint the last page whose vendors, and the ones of all pages before it, are
+// completed, so that a resumed run doesn't read those pages again. A nil pageTracker records nothing.
+type pageTracker struct {
+	mu         sync.Mutex
+	checkpoint *checkpoint.Store
+	geid       string
+	pages      []*trackedPage
+	logger     *slog.Logger
+	// recorded is the number of pages recorded so far.
+	recorded int
+	// isBlocked is set once a vendor isn't completed, pages after it are never recorded in this run.
+	isBlocked bool
+}
+
+type trackedPage struct {
+	resume  checkpoint.Page
+	pending int
+}
+
+// add tracks a page with the number of vendors selected from it and returns its index.
+func (t *pageTracker) add(resume checkpoint.Page, selected int) int {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pages = append(t.pages, &trackedPage{resume: resume, pending: selected})
+	t.recordCompleted()
+	return len(t.pages) - 1
+}
+
+// done marks a vendor of the page as processed, isCompleted tells whether it doesn't need to be patched again.
+func (t *pageTracker) done(page int, isCompleted bool) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !isCompleted {
+		t.isBlocked = true
+		return
+	}
+
+	t.pages[page].pending--
+	t.recordCompleted()
+}
+
+// recordCompleted records the pages completed in order, t.mu must be held.
+func (t *pageTracker) recordCompleted() {
+	for !t.isBlocked && t.recorded < len(t.pages) && t.pages[t.recorded].pending == 0 {
+		page := t.pages[t.recorded]
+		t.recorded++
+
+		// the last page has no key to resume after.
+		if page.resume.Key == "" {
+			continue
+		}
+		if err := t.checkpoint.RecordPage(t.geid, page.resume); err != nil {
+			t.logger.Error("Failed to checkpoint vendor page", "error", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/audit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/journal"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/ratelimit"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/repository/tovendor"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+const undoCommand = "undo"
+
+// runUndo replays the journal of a run in reverse and restores the attributes it wrote.
+// Items changed since the run are reported instead of overwritten.
+func runUndo(args []string) {
+	var runIDFlag string
+
+	fs := flag.NewFlagSet(undoCommand, flag.ExitOnError)
+	fs.StringVar(&runIDFlag, "run", "", "[Required] The id of the run to undo.")
+	fs.StringVar(&outputDirFlag, "output-dir", "output", "The directory where run artifacts are written to.")
+	fs.DurationVar(&gracePeriodFlag, "grace-period", 30*time.Second, "How long the in-flight restore can take to finish after SIGINT or SIGTERM.")
+	fs.BoolVar(&batchWritesFlag, "batch-writes", false, "Restore up to 25 vendors at once with TransactWriteItems. A vendor changed since the run is reported like without it.")
+	fs.Float64Var(&ddbWPSFlag, "ddb-wps", 0, "The maximum DynamoDB writes per second of the restores and their audit. 0 means unlimited.")
+	fs.StringVar(&configFlag, "config", "", "The YAML file of environments the run used, if any. The environment of the run is read from its journal, its table, region and endpoint are the ones the run wrote to.")
+	fs.StringVar(&backendFlag, "backend", backendAWS, "The DynamoDB backend, aws or memory.")
+	fs.StringVar(&fixtureFlag, "fixture", "", "The tables of memory backend, e.g. the "+memoryTablesFile+" saved by the rehearsed run.")
+	fs.StringVar(&auditFlag, "audit", auditSinkFile, "Where the restores are audited, file, table or off. See audit flag of the patch.")
+	fs.StringVar(&auditFileFlag, "audit-file", "", "The JSONL file of audit flag file, it defaults to "+audit.FileName+" under the output directory.")
+	fs.StringVar(&logFormatFlag, "log-format", logFormatText, "The format of log lines, text or json.")
+	fs.StringVar(&logLevelFlag, "log-level", "info", "The minimum level of log lines, debug, info, warn or error.")
+	fs.Parse(args)
+	setupLogging(os.Stderr)
+
+	if runIDFlag == "" {
+		fatal(slog.Default(), "run flag is required")
+	}
+	logger := slog.Default().With("run_id", runIDFlag)
+
+	if err := validateAuditFlag(); err != nil {
+		fatal(logger, "Invalid flags", "error", err)
+	}
+
+	if err := openBackend(); err != nil {
+		fatal(logger, "Failed to open backend", "error", err)
+	}
+
+	auditFile, err := openAudit()
+	if err != nil {
+		fatal(logger, "Failed to open audit", "error", err)
+	}
+	if auditFile != nil {
+		defer auditFile.Close()
+	}
+
+	entries, err := journal.Read(runDir(runIDFlag), logger)
+	if err != nil {
+		fatal(logger, "Failed to read journal", "error", err)
+	}
+
+	scheduleCtx, workCtx, stop := withShutdown(context.Background(), gracePeriodFlag)
+	defer stop()
+
+	writeLimiter := ratelimit.New("dynamodb_write", ddbWPSFlag)
+	repositories := map[string]*tovendor.DDBRepository{}
+	var restored, conflicts, failures int
+
+	record := func(entry journal.Entry, err error) {
+		entryLogger := logger.With("env", entry.Env, "geid", entry.GEID, "vendor_code", entry.VendorCode, "attribute", entry.Attribute)
+		switch {
+		case errors.Is(err, tovendor.ErrConcurrentlyModified):
+			conflicts++
+			entryLogger.Warn("Skip the vendor, it was changed after the run", "outcome", "conflict")
+		case err != nil:
+			failures++
+			entryLogger.Error("Failed to restore", "outcome", "failed", "error", err)
+		default:
+			restored++
+			entryLogger.Info("Restored", "outcome", "restored", "previous", describeValue(entry.Previous))
+		}
+	}
+
+	// batch holds the entries of a repository to restore together when writes are batched.
+	var batch []journal.Entry
+	var batchRepo *tovendor.DDBRepository
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		restores := make([]tovendor.Restore, 0, len(batch))
+		for _, entry := range batch {
+			restores = append(restores, tovendor.Restore{
+				VendorCode: entry.VendorCode,
+				Attribute:  entry.Attribute,
+				Written:    entry.Written,
+				Previous:   entry.Previous,
+			})
+		}
+
+		for i, err := range batchRepo.RestoreAttributes(workCtx, restores) {
+			record(batch[i], err)
+		}
+		batch = nil
+	}
+
+	_, isBatched := batchConfig()
+	for i := len(entries) - 1; i >= 0 && scheduleCtx.Err() == nil; i-- {
+		entry := entries[i]
+
+		repo, err := undoRepository(repositories, entry, auditFile, writeLimiter)
+		if err != nil {
+			fatal(logger, "Failed to initialize repository", "env", entry.Env, "geid", entry.GEID, "error", err)
+		}
+
+		if !isBatched {
+			record(entry, repo.RestoreAttribute(workCtx, entry.VendorCode, entry.Attribute, entry.Written, entry.Previous))
+			continue
+		}
+
+		if repo != batchRepo || len(batch) == tovendor.MaxBatchSize {
+			flush()
+			batchRepo = repo
+		}
+		batch = append(batch, entry)
+	}
+	if scheduleCtx.Err() == nil {
+		flush()
+	}
+
+	saveBackend(runIDFlag, memoryTablesUndoneFile)
+	logger.Info("Undo completed", "restored", restored, "conflicts", conflicts, "failed", failures)
+	if scheduleCtx.Err() != nil {
+		fatal(logger, "Undo is interrupted", "outcome", "interrupted", "not_replayed", len(entries)-restored-conflicts-failures)
+	}
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// undoRepository returns the repository of the table and GEID of entry, auditing its restores as the undo of the run.
+// The table of older entries is read from the config of their env.
+func undoRepository(repositories map[string]*tovendor.DDBRepository, entry journal.Entry, auditFile *audit.File, writeLimiter *ratelimit.Limiter) (*tovendor.DDBRepository, error) {
+	key := fmt.Sprintf("%s#%s#%s#%s#%s", entry.Env, entry.Table, entry.Region, entry.Endpoint, entry.GEID)
+	if repo, ok := repositories[key]; ok {
+		return repo, nil
+	}
+
+	cfg, err := loadConfig(configFlag, entry.Env)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Table != "" {
+		cfg.AWS.DynamoDBTableName = entry.Table
+		cfg.AWS.Region = entry.Region
+		cfg.AWS.DynamoDBEndpoint = entry.Endpoint
+	}
+
+	globalEntity, err := utils.NewGlobalEntity(entry.GEID)
+	if err != nil {
+		return nil, err
+	}
+
+	ddbClient, err := newDDBClient(cfg.AWS)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []tovendor.Option{tovendor.WithWriteLimiter(writeLimiter)}
+	if batchCfg, ok := batchConfig(); ok {
+		opts = append(opts, tovendor.WithBatchWrites(batchCfg))
+	}
+	opts = withAudit(opts, auditFile, ddbClient, cfg.AWS.DynamoDBTableName, writeLimiter, tovendor.AuditMetadata{
+		Operator: operator(),
+		RunID:    entry.RunID,
+		Env:      entry.Env,
+		Target:   undoCommand,
+	})
+
+	repo := tovendor.NewDDBRepository(globalEntity, cfg, ddbClient, opts...)
+	repositories[key] = repo
+	return repo, nil
+}
+
+func describeValue(value *string) string {
+	if value == nil {
+		return "absent"
+	}
+	return fmt.Sprintf("%q", *value)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/clients/retryhttp"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/report"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/source"
+	"github.com/deliveryhero/pd-dine-in-box/script/dynamodb_patcher/utils"
+)
+
+const verifyCommand = "verify"
+
+// verifiable is a patcher exposing what it writes and where the value comes from, so verify can reuse it.
+type verifiable interface {
+	Attribute() string
+	Source() source.Source
+}
+
+// runVerify audits the target attribute of every GEID against the source of the patcher, it never writes.
+func runVerify(r *run, globalEntities []utils.GlobalEntity) {
+	scheduleCtx, workCtx, stop := withShutdown(context.Background(), gracePeriodFlag)
+	defer stop()
+
+	for _, globalEntity := range globalEntities {
+		logger := r.geidLogger(globalEntity)
+		audit, err := verify(scheduleCtx, workCtx, r, globalEntity)
+		if scheduleCtx.Err() != nil {
+			fatal(logger, "Verify is interrupted", "outcome", "interrupted")
+		}
+		if err != nil {
+			fatal(logger, "Failed to verify", "outcome", "failed", "error", err)
+		}
+
+		logger.Info("Verified", "outcome", "completed", "audit", audit.String())
+		if err := audit.Write(runDir(r.id)); err != nil {
+			fatal(logger, "Failed to write audit report", "error", err)
+		}
+	}
+
+	r.logger.Info("Audit reports are saved", "path", runDir(r.id))
+}
+
+// verify audits the vendors of globalEntity, it's aborted on the first fatal error of the source.
+func verify(scheduleCtx, workCtx context.Context, r *run, globalEntity utils.GlobalEntity) (*report.Audit, error) {
+	scheduleCtx, workCtx, abort := withAbort(scheduleCtx, workCtx)
+	defer abort(nil)
+
+	wl, err := loadWorkload(r, globalEntity)
+	if err != nil {
+		return nil, err
+	}
+
+	target, ok := wl.patcher.(verifiable)
+	if !ok {
+		fatal(r.logger, "Target doesn't support verify")
+	}
+
+	src := target.Source()
+	audit := report.NewAudit(r.cfg.Env, globalEntity.ID, r.target, target.Attribute(), src.String())
+
+	stream := streamVendors(scheduleCtx, r, globalEntity, wl, nil)
+	defer stream.stop()
+
+	var wg sync.WaitGroup
+
+	for {
+		queued, ok := stream.next(scheduleCtx)
+		if !ok {
+			break
+		}
+		vendor := queued.vendor
+
+		if err := r.budget.acquire(scheduleCtx, globalEntity.ID); err != nil {
+			break
+		}
+		wg.Add(1)
+
+		go func(code, tableValue string) {
+			defer func() {
+				wg.Done()
+				r.budget.release(globalEntity.ID)
+			}()
+
+			discrepancy := report.Discrepancy{
+				VendorCode: code,
+				TableValue: tableValue,
+			}
+
+			sourceValue, err := src.Lookup(workCtx, code)
+			switch {
+			case retryhttp.IsNotFound(err):
+				discrepancy.Class = report.ClassMissingInSource
+				discrepancy.Error = err.Error()
+			case err != nil:
+				discrepancy.Class = report.ClassSourceError
+				discrepancy.Error = err.Error()
+				if retryhttp.IsFatal(err) {
+					abort(err)
+				}
+			default:
+				discrepancy.SourceValue = sourceValue
+				discrepancy.Class = report.Classify(tableValue, sourceValue)
+			}
+			audit.Add(discrepancy)
+		}(vendor.Code, vendor.Attribute(target.Attribute()))
+	}
+	wg.Wait()
+
+	if cause := context.Cause(scheduleCtx); retryhttp.IsFatal(cause) {
+		return audit, fmt.Errorf("aborted on a fatal error: %w", cause)
+	}
+	if scheduleCtx.Err() != nil {
+		return audit, scheduleCtx.Err()
+	}
+	if err := stream.stop(); err != nil {
+		return nil, fmt.Errorf("failed to get vendor list: %w", err)
+	}
+
+	return audit, nil
+}